@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Add-on tiers, priced in this order. Third tier add-ons finish the other
+// side, so they are only priced when both sides are printed.
+const (
+	AddOnTierPrimary   = "primary"
+	AddOnTierSecondary = "secondary"
+	AddOnTierThird     = "third"
+)
+
+// Add-on price tables have the add-on, spec and quantity first in the standard layout
+const addOnQuantityColumn = 2
+
+// AddOnType describes one kind of add-on and where its prices are, so a new
+// finish only needs a catalog entry and rows in the price sheet.
+type AddOnType struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Tier  string `json:"tier"`
+	// Table is the price range holding the add-on's rows
+	Table string `json:"table"`
+	// RowLabel is the first column of the add-on's rows. When empty the rows
+	// are labelled with the selected option instead, e.g. "matt lam 1side".
+	RowLabel string `json:"rowLabel,omitempty"`
+	// Spec says what the second column holds: the selected "option" or the "size" category
+	Spec string `json:"spec"`
+	// Zero-based price columns, numbered as in the standard add-on layout
+	// [add-on, spec, quantity, single, double] whatever order the sheet
+	// has them in. DoubleSideColumn is used for double side printing when set.
+	SingleSideColumn int `json:"singleSideColumn"`
+	DoubleSideColumn int `json:"doubleSideColumn,omitempty"`
+	// None is the option meaning the add-on isn't wanted. It is never priced
+	// and is left out of the quotation summary.
+	None    string          `json:"none,omitempty"`
+	Options []CatalogOption `json:"options"`
+	// Cost of the options without a cost of their own, for the cost engine
+	Cost *FinishingCost `json:"cost,omitempty"`
+	// Formula prices the options without a formula of their own instead of
+	// the table, see formula.go
+	Formula       string `json:"formula,omitempty"`
+	parsedFormula *formula
+}
+
+const (
+	AddOnSpecOption = "option"
+	AddOnSpecSize   = "size"
+)
+
+// SelectedAddOn is one add-on on a quotation, e.g. {"type": "hotstamping", "option": "within 16 square inch"}
+type SelectedAddOn struct {
+	Type   string `json:"type"`
+	Option string `json:"option"`
+}
+
+func (t AddOnType) check() error {
+	switch t.Tier {
+	case AddOnTierPrimary, AddOnTierSecondary, AddOnTierThird:
+	default:
+		return fmt.Errorf("invalid catalog: add-on %s has tier %q, expected %s, %s or %s", t.Key, t.Tier, AddOnTierPrimary, AddOnTierSecondary, AddOnTierThird)
+	}
+	if t.Spec != AddOnSpecOption && t.Spec != AddOnSpecSize {
+		return fmt.Errorf("invalid catalog: add-on %s has spec %q, expected %s or %s", t.Key, t.Spec, AddOnSpecOption, AddOnSpecSize)
+	}
+	if t.Formula != "" {
+		return nil
+	}
+	if t.Table == "" {
+		return fmt.Errorf("invalid catalog: add-on %s has no price table or formula", t.Key)
+	}
+	if t.SingleSideColumn <= addOnQuantityColumn || (t.DoubleSideColumn != 0 && t.DoubleSideColumn <= addOnQuantityColumn) {
+		return fmt.Errorf("invalid catalog: add-on %s price columns must come after the quantity column %d", t.Key, addOnQuantityColumn)
+	}
+	return nil
+}
+
+// option returns the catalog key for value, matching case-insensitively so
+// older clients sending "spotUV1side" still match "spotUV1Side"
+func (t AddOnType) option(value string) (CatalogOption, bool) {
+	for _, option := range t.Options {
+		if option.Key == value {
+			return option, true
+		}
+	}
+	for _, option := range t.Options {
+		if strings.EqualFold(option.Key, value) {
+			return option, true
+		}
+	}
+	return CatalogOption{}, false
+}
+
+// summary is how a selected option reads in the quotation summary,
+// e.g. "hot stamping within 16 square inch"
+func (t AddOnType) summary(option string) string {
+	label := option
+	if t.RowLabel != "" {
+		label = t.RowLabel
+		if t.Spec == AddOnSpecOption {
+			label += " " + option
+		}
+	}
+	if t.Tier == AddOnTierThird {
+		label += " another side"
+	}
+	return label
+}
+
+// lineItemLabel is the label and option of a selected option's line item,
+// e.g. "hot stamping" and "within 16 square inch", or "matt lam 1side" alone
+func (t AddOnType) lineItemLabel(option string) (string, string) {
+	if t.RowLabel == "" {
+		return option, ""
+	}
+	if t.Spec == AddOnSpecOption {
+		return t.RowLabel, option
+	}
+	return t.RowLabel, ""
+}
+
+// Legacy add-on groups, still accepted from older clients
+type ThirdAddOns struct {
+	IsDoubleSide         bool   `json:"isDoubleSide"`
+	FinishingAnotherSide string `json:"finishingAnotherSide"`
+}
+
+type SecondaryAddOns struct {
+	SpotUV1Side                          string `json:"spotUV1Side"`
+	WindowHoleWithoutTransparentPVCSheet string `json:"windowHoleWithoutTransparentPVCSheet"`
+	WindowHoleWithTransparentPVCSheet    string `json:"windowHoleWithTransparentPVCSheet"`
+	Hotstamping                          string `json:"hotstamping"`
+	EmbossDeboss                         string `json:"embossDeboss"`
+	String                               string `json:"string"`
+}
+
+type PrimaryAddOns struct {
+	SurfaceProtectionPrinting string `json:"surfaceProtectionPrinting"`
+}
+
+// normaliseAddOns moves add-ons sent in the legacy groups into AddOns, and
+// spells every option the way the catalog does
+func (q *Quotation) normaliseAddOns(catalog *Catalog) {
+	q.moveLegacyAddOns()
+	for i, addOn := range q.AddOns {
+		if t, ok := catalog.addOn(addOn.Type); ok {
+			if option, ok := t.option(addOn.Option); ok {
+				q.AddOns[i].Option = option.Key
+			}
+		}
+	}
+}
+
+// moveLegacyAddOns moves add-ons sent in the legacy primaryAddOns,
+// secondaryAddOns and thirdAddOns groups into AddOns. Records stored before
+// add-ons were data driven go through here too, without a catalog, since a
+// record keeps the options as they were quoted.
+func (q *Quotation) moveLegacyAddOns() {
+	legacy := []SelectedAddOn{}
+	if q.PrimaryAddOns != nil {
+		legacy = append(legacy, SelectedAddOn{"surfaceProtectionPrinting", q.PrimaryAddOns.SurfaceProtectionPrinting})
+	}
+	if q.SecondaryAddOns != nil {
+		legacy = append(legacy,
+			SelectedAddOn{"spotUV1Side", q.SecondaryAddOns.SpotUV1Side},
+			SelectedAddOn{"windowHoleWithoutTransparentPVCSheet", q.SecondaryAddOns.WindowHoleWithoutTransparentPVCSheet},
+			SelectedAddOn{"windowHoleWithTransparentPVCSheet", q.SecondaryAddOns.WindowHoleWithTransparentPVCSheet},
+			SelectedAddOn{"hotstamping", q.SecondaryAddOns.Hotstamping},
+			SelectedAddOn{"embossDeboss", q.SecondaryAddOns.EmbossDeboss},
+			SelectedAddOn{"string", q.SecondaryAddOns.String},
+		)
+	}
+	if q.ThirdAddOns != nil {
+		q.IsDoubleSide = q.IsDoubleSide || q.ThirdAddOns.IsDoubleSide
+		legacy = append(legacy, SelectedAddOn{"finishingAnotherSide", q.ThirdAddOns.FinishingAnotherSide})
+	}
+	for _, addOn := range legacy {
+		if addOn.Option != "" && q.addOnOption(addOn.Type) == "" {
+			q.AddOns = append(q.AddOns, addOn)
+		}
+	}
+	q.PrimaryAddOns, q.SecondaryAddOns, q.ThirdAddOns = nil, nil, nil
+}
+
+// addOnOption returns the option selected for an add-on type, or "" when
+// the add-on isn't on the quotation
+func (q *Quotation) addOnOption(key string) string {
+	for _, addOn := range q.AddOns {
+		if addOn.Type == key {
+			return addOn.Option
+		}
+	}
+	return ""
+}
+
+// printsBothSides is when the other side is printed, and can take third tier
+// add-ons. The catalog rules keep 0colour quotations single sided.
+func (q *Quotation) printsBothSides() bool {
+	return q.IsDoubleSide
+}
+
+// addOnSelection is an add-on type with the option picked for it
+type addOnSelection struct {
+	addOnType AddOnType
+	option    string
+}
+
+// selectedAddOns returns the add-ons of a tier that are to be priced, in catalog order
+func (q *Quotation) selectedAddOns(catalog *Catalog, tier string) []addOnSelection {
+	var selected []addOnSelection
+	if tier == AddOnTierThird && !q.printsBothSides() {
+		return selected
+	}
+	for _, t := range catalog.AddOns {
+		option := q.addOnOption(t.Key)
+		if t.Tier != tier || option == "" || option == t.None {
+			continue
+		}
+		selected = append(selected, addOnSelection{t, option})
+	}
+	return selected
+}
+
+func addOnLineItemKind(tier string) string {
+	switch tier {
+	case AddOnTierPrimary:
+		return LineItemPrimaryAddOn
+	case AddOnTierThird:
+		return LineItemAnotherSideFinishing
+	}
+	return LineItemSecondaryAddOn
+}
+
+// getAddOns prices every selected add-on of a tier. Line items from the same
+// table follow the order of its rows, and those priced by formula come last.
+func (q *Quotation) getAddOns(tables priceTables, catalog *Catalog, tier string, pricingMap map[string]*Pricing) (map[string]*Pricing, error) {
+	type pricedAddOn struct {
+		addOnSelection
+		column int
+		table  quantityTable
+	}
+	var addOns []pricedAddOn
+	var formulaAddOns []addOnSelection
+	var tableOrder []string
+	for _, selected := range q.selectedAddOns(catalog, tier) {
+		t := selected.addOnType
+		if t.formulaFor(selected.option) != nil {
+			formulaAddOns = append(formulaAddOns, selected)
+			continue
+		}
+		addOnTable, err := tables.addOns(t.Table)
+		if err != nil {
+			return nil, err
+		}
+		if indexOf(tableOrder, t.Table) < 0 {
+			tableOrder = append(tableOrder, t.Table)
+		}
+		var search_string_rowLabel string = t.RowLabel
+		if search_string_rowLabel == "" {
+			search_string_rowLabel = selected.option
+		}
+		var search_string_specs []string = []string{selected.option}
+		if t.Spec == AddOnSpecSize {
+			search_string_specs = q.sizes(catalog)
+		}
+		position := t.SingleSideColumn
+		if q.IsDoubleSide && t.DoubleSideColumn != 0 {
+			position = t.DoubleSideColumn
+		}
+		table, column, err := addOnTable.lookup(search_string_rowLabel, search_string_specs, position)
+		if err != nil {
+			return nil, err
+		}
+		addOns = append(addOns, pricedAddOn{selected, column, table})
+	}
+
+	for _, quantity := range q.Quantity {
+		pricing, ok := pricingMap[strconv.Itoa(quantity)]
+		if !ok {
+			continue
+		}
+		type positionedItem struct {
+			item     LineItem
+			table    int
+			position int
+		}
+		var items []positionedItem
+		for _, addOn := range addOns {
+			quoted, err := addOn.table.priceAt(quantity, addOn.column)
+			if err != nil {
+				return nil, err
+			}
+			// an add-on without rows for its spec goes after the table's
+			position := math.MaxInt
+			if len(addOn.table.rows) > 0 {
+				position = addOn.table.rows[0].index
+			}
+			if quoted.Source != nil {
+				position = quoted.Source.Row - 1
+			}
+			label, option := addOn.addOnType.lineItemLabel(addOn.option)
+			items = append(items, positionedItem{newLineItem(addOnLineItemKind(tier), label, option, quoted), indexOf(tableOrder, addOn.table.range_), position})
+		}
+		for i, addOn := range formulaAddOns {
+			items = append(items, positionedItem{q.formulaLineItem(catalog, tier, addOn, quantity), len(tableOrder), i})
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			if items[i].table != items[j].table {
+				return items[i].table < items[j].table
+			}
+			return items[i].position < items[j].position
+		})
+		for _, item := range items {
+			pricing.addLineItem(item.item)
+		}
+	}
+	return pricingMap, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+)
+
+// adminMiddleware protects the /admin routes with HTTP basic auth using
+// ADMIN_USER (default "admin") and ADMIN_PASSWORD. Without a password the admin
+// routes stay disabled.
+func adminMiddleware() fiber.Handler {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return func(c *fiber.Ctx) error {
+			return fiber.NewError(fiber.StatusForbidden, "admin access is not configured, set ADMIN_PASSWORD")
+		}
+	}
+	user := os.Getenv("ADMIN_USER")
+	if user == "" {
+		user = "admin"
+	}
+	return basicauth.New(basicauth.Config{
+		Users: map[string]string{user: password},
+		Realm: "Cetak Copilot Admin",
+	})
+}
+
+// adminCSRF stops other sites posting to the admin routes with the login the
+// browser has saved. A post from a browser has to come from this server's own
+// pages, and a form has to carry the token the admin page renders as "csrf".
+// Posts without a form body or an Origin, such as curl's, only need the login.
+func adminCSRF() fiber.Handler {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	return func(c *fiber.Ctx) error {
+		c.Locals("csrf", token)
+		if c.Method() != fiber.MethodPost {
+			return c.Next()
+		}
+		if !sameOrigin(c) {
+			return fiber.NewError(fiber.StatusForbidden, "admin requests from another site are refused")
+		}
+		if isFormPost(c) && subtle.ConstantTimeCompare([]byte(c.FormValue("csrf")), []byte(token)) != 1 {
+			return fiber.NewError(fiber.StatusForbidden, "missing or wrong csrf token, reload the admin page and try again")
+		}
+		return c.Next()
+	}
+}
+
+// sameOrigin is whether a request names this server, or nothing, as where it
+// came from
+func sameOrigin(c *fiber.Ctx) bool {
+	origin := c.Get(fiber.HeaderOrigin)
+	if origin == "" {
+		origin = c.Get(fiber.HeaderReferer)
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, string(c.Request().Host()))
+}
+
+// isFormPost is whether a request has a body another site's form could send
+func isFormPost(c *fiber.Ctx) bool {
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+	for _, mime := range []string{fiber.MIMEApplicationForm, fiber.MIMEMultipartForm, fiber.MIMETextPlain} {
+		if strings.HasPrefix(contentType, mime) {
+			return true
+		}
+	}
+	return false
+}
+
+func registerPriceCacheRoutes(admin fiber.Router, cache *CachedPriceSource) {
+	admin.Get("/prices", func(c *fiber.Ctx) error {
+		return c.JSON(cache.Status())
+	})
+	admin.Post("/prices/refresh", func(c *fiber.Ctx) error {
+		if err := cache.Refresh(); err != nil {
+			return err
+		}
+		// the admin page posts here as a form and wants to land back on itself
+		if c.FormValue("redirect") == "/admin/google" {
+			return c.Redirect("/admin/google", fiber.StatusSeeOther)
+		}
+		return c.JSON(cache.Status())
+	})
+}
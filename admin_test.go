@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdminCSRF(t *testing.T) {
+	var token string
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	admin := app.Group("/admin", adminCSRF())
+	admin.Get("/page", func(c *fiber.Ctx) error {
+		token = c.Locals("csrf").(string)
+		return nil
+	})
+	admin.Post("/refresh", func(c *fiber.Ctx) error { return nil })
+	if _, err := app.Test(httptest.NewRequest("GET", "/admin/page", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"script", "", nil, 200},
+		{"admin page form", "csrf=" + token, map[string]string{"Origin": "http://example.com", "Content-Type": fiber.MIMEApplicationForm}, 200},
+		{"form without token", "redirect=/admin/google", map[string]string{"Content-Type": fiber.MIMEApplicationForm}, 403},
+		{"form with wrong token", "csrf=guess", map[string]string{"Referer": "http://example.com/admin/google", "Content-Type": fiber.MIMEApplicationForm}, 403},
+		{"text form without token", "x", map[string]string{"Content-Type": fiber.MIMETextPlain}, 403},
+		{"other site", "csrf=" + token, map[string]string{"Origin": "https://evil.example", "Content-Type": fiber.MIMEApplicationForm}, 403},
+		{"other site without body", "", map[string]string{"Referer": "https://evil.example/page"}, 403},
+		{"opaque origin", "", map[string]string{"Origin": "null"}, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/admin/refresh", strings.NewReader(tt.body))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("got %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
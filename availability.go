@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Availability is whether a line item, or a whole quantity, can be quoted,
+// and why not. A quantity with an unavailable line item has no total.
+type Availability struct {
+	Available bool     `json:"available"`
+	Reasons   []string `json:"unavailableReasons,omitempty"`
+}
+
+func available() Availability {
+	return Availability{Available: true}
+}
+
+func unavailable(reasons ...string) Availability {
+	return Availability{Reasons: reasons}
+}
+
+// reason is every reason in one sentence
+func (a Availability) reason() string {
+	return strings.Join(a.Reasons, "; ")
+}
+
+// name is what a line item is called in an unavailable reason, e.g.
+// "hot stamping within 16 square inch"
+func (i LineItem) name() string {
+	if i.Option != "" {
+		return i.Label + " " + i.Option
+	}
+	return i.Label
+}
+
+// unavailableReason is why a line item without a price can't be quoted
+func (i LineItem) unavailableReason(quantity int) string {
+	return fmt.Sprintf("%s is not available for %d pcs", i.name(), quantity)
+}
+
+// MarshalJSON leaves out the price of an unavailable line item
+func (i LineItem) MarshalJSON() ([]byte, error) {
+	type lineItem LineItem
+	var price *Money
+	if i.Available {
+		price = &i.Price
+	}
+	return json.Marshal(struct {
+		lineItem
+		Price *Money `json:"price"`
+	}{lineItem(i), price})
+}
+
+// MarshalJSON leaves out the total of a quantity that can't be quoted
+func (p Pricing) MarshalJSON() ([]byte, error) {
+	type pricing Pricing
+	var total *Money
+	if p.Available {
+		total = &p.Total
+	}
+	return json.Marshal(struct {
+		pricing
+		Total *Money `json:"total"`
+	}{pricing(p), total})
+}
+
+// UnmarshalJSON also reads line items stored when prices were text, with
+// "not available" or a blank for a missing price
+func (i *LineItem) UnmarshalJSON(b []byte) error {
+	type lineItem LineItem
+	stored := struct {
+		*lineItem
+		Price json.RawMessage `json:"price"`
+	}{lineItem: (*lineItem)(i)}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return err
+	}
+	var err error
+	i.Price, i.Available, err = storedMoney(stored.Price)
+	return err
+}
+
+func (p *Pricing) UnmarshalJSON(b []byte) error {
+	type pricing Pricing
+	stored := struct {
+		*pricing
+		Total json.RawMessage `json:"total"`
+	}{pricing: (*pricing)(p)}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return err
+	}
+	var err error
+	p.Total, p.Available, err = storedMoney(stored.Total)
+	if !p.Available && len(p.Reasons) == 0 {
+		for _, item := range p.LineItems {
+			if !item.Available {
+				p.Reasons = append(p.Reasons, item.unavailableReason(p.Quantity))
+			}
+		}
+	}
+	return err
+}
+
+// storedMoney reads a stored price, which is available unless it is null,
+// "not available" or blank
+func storedMoney(raw json.RawMessage) (Money, bool, error) {
+	var text string
+	if len(raw) == 0 || string(raw) == "null" || (json.Unmarshal(raw, &text) == nil && (text == "" || text == "not available")) {
+		return 0, false, nil
+	}
+	var m Money
+	err := m.UnmarshalJSON(raw)
+	return m, err == nil, err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// BoxStyle says how big the flat blank of a box style is. Each side of the
+// blank is a multiple of the box's length, width and height plus a fixed
+// amount for the tucks and dust flaps.
+type BoxStyle struct {
+	Key    string    `json:"key"`
+	Label  string    `json:"label"`
+	Width  BlankEdge `json:"width"`
+	Height BlankEdge `json:"height"`
+	// GlueFlapMM is added to the width, BleedMM to every edge
+	GlueFlapMM float64 `json:"glueFlapMm,omitempty"`
+	BleedMM    float64 `json:"bleedMm,omitempty"`
+}
+
+// BlankEdge is one side of a blank, e.g. 2 lengths + 2 widths for the
+// panels round a tuck end box
+type BlankEdge struct {
+	Length  float64 `json:"length,omitempty"`
+	Width   float64 `json:"width,omitempty"`
+	Height  float64 `json:"height,omitempty"`
+	ExtraMM float64 `json:"extraMm,omitempty"`
+}
+
+func (e BlankEdge) of(box *BoxDimensions) float64 {
+	return e.Length*box.LengthMM + e.Width*box.WidthMM + e.Height*box.HeightMM + e.ExtraMM
+}
+
+// BoxDimensions quotes a box by its size rather than a size category. The
+// blank is worked out when the quotation is validated.
+type BoxDimensions struct {
+	Style    string  `json:"style"`
+	LengthMM float64 `json:"lengthMm"`
+	WidthMM  float64 `json:"widthMm"`
+	HeightMM float64 `json:"heightMm"`
+
+	BlankWidthMM  float64 `json:"blankWidthMm,omitempty"`
+	BlankHeightMM float64 `json:"blankHeightMm,omitempty"`
+}
+
+// maxBoxSideMM is far beyond any press sheet, to catch centimetres typed as metres
+const maxBoxSideMM = 2000
+
+func (c *Catalog) normaliseBoxStyles() error {
+	seen := make(map[string]bool, len(c.BoxStyles))
+	for i := range c.BoxStyles {
+		style := &c.BoxStyles[i]
+		if style.Key == "" {
+			return errors.New("invalid catalog: box style without a key")
+		}
+		if seen[strings.ToLower(style.Key)] {
+			return fmt.Errorf("invalid catalog: box style %s is listed more than once", style.Key)
+		}
+		seen[strings.ToLower(style.Key)] = true
+		if style.Label == "" {
+			style.Label = style.Key
+		}
+		for _, edge := range []BlankEdge{style.Width, style.Height} {
+			if edge.Length < 0 || edge.Width < 0 || edge.Height < 0 || edge.ExtraMM < 0 {
+				return fmt.Errorf("invalid catalog: box style %s has a negative blank multiple", style.Key)
+			}
+			if edge.Length == 0 && edge.Width == 0 && edge.Height == 0 {
+				return fmt.Errorf("invalid catalog: box style %s has a blank edge that doesn't grow with the box", style.Key)
+			}
+		}
+		if style.GlueFlapMM < 0 || style.BleedMM < 0 {
+			return fmt.Errorf("invalid catalog: box style %s has a negative glue flap or bleed", style.Key)
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) boxStyle(key string) (BoxStyle, bool) {
+	for _, style := range c.BoxStyles {
+		if strings.EqualFold(style.Key, key) {
+			return style, true
+		}
+	}
+	return BoxStyle{}, false
+}
+
+// blank is the flat blank of box, rounded up to the whole mm
+func (s BoxStyle) blank(box *BoxDimensions) (width float64, height float64) {
+	width = s.Width.of(box) + s.GlueFlapMM + 2*s.BleedMM
+	height = s.Height.of(box) + 2*s.BleedMM
+	return math.Ceil(width), math.Ceil(height)
+}
+
+// smallestSizeFor is the enabled size with the least area that a blank fits
+// on, either way round
+func (c *Catalog) smallestSizeFor(width float64, height float64) (CatalogOption, bool) {
+	var smallest CatalogOption
+	found := false
+	for _, size := range enabledOptions(c.SizeCategories) {
+		spec := c.sizeSpec(size.Key)
+		if spec.AreaMM2() == 0 {
+			continue
+		}
+		fits := (width <= spec.WidthMM && height <= spec.HeightMM) || (width <= spec.HeightMM && height <= spec.WidthMM)
+		if fits && (!found || spec.AreaMM2() < c.sizeSpec(smallest.Key).AreaMM2()) {
+			smallest, found = size, true
+		}
+	}
+	return smallest, found
+}
+
+// applyBox works out the blank of a quotation given as a box and picks the
+// size category it is printed on
+func (q *Quotation) applyBox(errs *ValidationErrors, catalog *Catalog) {
+	if q.Box == nil {
+		return
+	}
+	box := q.Box
+	box.BlankWidthMM, box.BlankHeightMM = 0, 0
+	style, ok := catalog.boxStyle(box.Style)
+	if !ok {
+		keys := make([]string, 0, len(catalog.BoxStyles))
+		for _, style := range catalog.BoxStyles {
+			keys = append(keys, style.Key)
+		}
+		errs.checkOneOf("box.style", box.Style, keys)
+		return
+	}
+	box.Style = style.Key
+	valid := true
+	for _, side := range []struct {
+		field string
+		value float64
+	}{{"box.lengthMm", box.LengthMM}, {"box.widthMm", box.WidthMM}, {"box.heightMm", box.HeightMM}} {
+		if side.value <= 0 || side.value > maxBoxSideMM {
+			errs.add(side.field, "must be between 0 and %d mm", maxBoxSideMM)
+			valid = false
+		}
+	}
+	if !valid {
+		return
+	}
+	box.BlankWidthMM, box.BlankHeightMM = style.blank(box)
+	size, ok := catalog.smallestSizeFor(box.BlankWidthMM, box.BlankHeightMM)
+	if !ok {
+		errs.add("box", "the %g x %g mm blank of this box is larger than every size", box.BlankWidthMM, box.BlankHeightMM)
+		return
+	}
+	q.SizeCategory = size.Key
+}
+
+// blankText is how the blank shows on a quotation, e.g.
+// "341 x 186 mm (100 x 60 x 30 mm tuck end box)"
+func (q *Quotation) blankText(catalog *Catalog) string {
+	box := q.Box
+	if box == nil || box.BlankWidthMM == 0 {
+		return ""
+	}
+	style := box.Style
+	if boxStyle, ok := catalog.boxStyle(box.Style); ok {
+		style = boxStyle.Label
+	}
+	return fmt.Sprintf("%g x %g mm (%g x %g x %g mm %s box)", box.BlankWidthMM, box.BlankHeightMM, box.LengthMM, box.WidthMM, box.HeightMM, style)
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultCatalogJSON is served when there is no catalog file or sheet tab
+//
+//go:embed catalog.json
+var defaultCatalogJSON []byte
+
+// CatalogOption is one choice in a catalog list. Key is what quotations send
+// and what the price sheets are matched on, Label is what customers see.
+type CatalogOption struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	SortOrder int    `json:"sortOrder"`
+	Enabled   *bool  `json:"enabled,omitempty"`
+	// Area of an add-on option, e.g. 16 for "within 16 square inch", for
+	// the maxAreaForSize rules and the sizes' maxStampArea
+	Area float64 `json:"area,omitempty"`
+	// Size categories carry their dimensions and limits
+	*SizeSpec
+	// PaperCosts of a material are its cost per parent sheet of each press
+	PaperCosts map[string]Money `json:"paperCosts,omitempty"`
+	// Cost of an add-on option for the cost engine
+	Cost *FinishingCost `json:"cost,omitempty"`
+	// Formula prices an add-on option instead of its add-on's table or formula
+	Formula       string `json:"formula,omitempty"`
+	parsedFormula *formula
+	// Gsm of a material, for add-on formulas
+	Gsm float64 `json:"gsm,omitempty"`
+}
+
+// IsEnabled treats a missing enabled flag as enabled
+func (o CatalogOption) IsEnabled() bool {
+	return o.Enabled == nil || *o.Enabled
+}
+
+// CatalogGroup is a named list of options, e.g. the hot stamping sizes
+type CatalogGroup struct {
+	Key     string          `json:"key"`
+	Label   string          `json:"label"`
+	Tier    string          `json:"tier,omitempty"`
+	None    string          `json:"none,omitempty"`
+	Options []CatalogOption `json:"options"`
+}
+
+// Catalog is everything a quotation can be made of
+type Catalog struct {
+	Materials      []CatalogOption     `json:"materials"`
+	SizeCategories []CatalogOption     `json:"sizeCategories"`
+	Quantities     []CatalogOption     `json:"quantities"`
+	NoOfColours    []CatalogOption     `json:"noOfColours"`
+	Shapes         []CatalogOption     `json:"shapes"`
+	AddOns         []AddOnType         `json:"addOns"`
+	Rules          []CompatibilityRule `json:"rules"`
+	// CustomerTiers are optional, for discounts by customer tier
+	CustomerTiers []CatalogOption `json:"customerTiers,omitempty"`
+	Discounts     []DiscountRule  `json:"discounts,omitempty"`
+	// BoxStyles are the styles a box can be quoted in by its dimensions
+	BoxStyles []BoxStyle `json:"boxStyles,omitempty"`
+	// Presses are the presses the sizes name, with their parent sheets
+	Presses []Press `json:"presses,omitempty"`
+	// Costing is the margin of the cost engine
+	Costing *Costing `json:"costing,omitempty"`
+
+	// version is a hash of what the catalog was loaded from, set by the
+	// catalog store, so a catalog and its version are always read together
+	version string
+}
+
+func parseCatalog(b []byte) (*Catalog, error) {
+	catalog := new(Catalog)
+	if err := json.Unmarshal(b, catalog); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	if err := catalog.normalise(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// normalise sorts every list by sort order and checks that each list has
+// unique keys and at least one enabled option
+func (c *Catalog) normalise() error {
+	lists := []struct {
+		name    string
+		options []CatalogOption
+	}{
+		{"materials", c.Materials},
+		{"sizeCategories", c.SizeCategories},
+		{"quantities", c.Quantities},
+		{"noOfColours", c.NoOfColours},
+		{"shapes", c.Shapes},
+	}
+	if len(c.CustomerTiers) > 0 {
+		lists = append(lists, struct {
+			name    string
+			options []CatalogOption
+		}{"customerTiers", c.CustomerTiers})
+	}
+	addOns := make(map[string]bool, len(c.AddOns))
+	for i := range c.AddOns {
+		addOn := &c.AddOns[i]
+		if addOn.Key == "" {
+			return errors.New("invalid catalog: add-on without a key")
+		}
+		if addOns[addOn.Key] {
+			return fmt.Errorf("invalid catalog: add-on %s is listed more than once", addOn.Key)
+		}
+		addOns[addOn.Key] = true
+		if addOn.Label == "" {
+			addOn.Label = addOn.Key
+		}
+		if err := addOn.check(); err != nil {
+			return err
+		}
+		lists = append(lists, struct {
+			name    string
+			options []CatalogOption
+		}{"addOns." + addOn.Key, addOn.Options})
+	}
+	for _, list := range lists {
+		if err := normaliseOptions(list.name, list.options); err != nil {
+			return err
+		}
+	}
+	for _, addOn := range c.AddOns {
+		if _, ok := addOn.option(addOn.None); addOn.None != "" && !ok {
+			return fmt.Errorf("invalid catalog: add-on %s has no option %q", addOn.Key, addOn.None)
+		}
+	}
+	if err := c.parseFormulas(); err != nil {
+		return err
+	}
+	for _, option := range c.Quantities {
+		if quantity, err := strconv.Atoi(option.Key); err != nil || quantity <= 0 {
+			return fmt.Errorf("invalid catalog: quantity %q is not a positive number", option.Key)
+		}
+	}
+	if err := c.normaliseSizes(); err != nil {
+		return err
+	}
+	if err := c.normaliseBoxStyles(); err != nil {
+		return err
+	}
+	if err := c.normalisePresses(); err != nil {
+		return err
+	}
+	if err := c.normaliseCosting(); err != nil {
+		return err
+	}
+	if err := c.normaliseRules(); err != nil {
+		return err
+	}
+	return c.normaliseDiscounts()
+}
+
+func normaliseOptions(name string, options []CatalogOption) error {
+	if len(options) == 0 {
+		return fmt.Errorf("invalid catalog: %s is empty", name)
+	}
+	seen := make(map[string]bool, len(options))
+	enabled := 0
+	for i := range options {
+		option := &options[i]
+		if option.Key == "" {
+			return fmt.Errorf("invalid catalog: %s has an option without a key", name)
+		}
+		if seen[option.Key] {
+			return fmt.Errorf("invalid catalog: %s lists %q more than once", name, option.Key)
+		}
+		seen[option.Key] = true
+		if option.Label == "" {
+			option.Label = option.Key
+		}
+		if option.IsEnabled() {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		return fmt.Errorf("invalid catalog: %s has no enabled options", name)
+	}
+	sort.SliceStable(options, func(i, j int) bool { return options[i].SortOrder < options[j].SortOrder })
+	return nil
+}
+
+// enabledOptions returns the options customers can pick, in sort order
+func enabledOptions(options []CatalogOption) []CatalogOption {
+	enabled := make([]CatalogOption, 0, len(options))
+	for _, option := range options {
+		if option.IsEnabled() {
+			// costs and price formulas are never shown to customers
+			option.PaperCosts, option.Cost, option.Formula, option.parsedFormula = nil, nil, "", nil
+			enabled = append(enabled, option)
+		}
+	}
+	return enabled
+}
+
+func enabledKeys(options []CatalogOption) []string {
+	keys := make([]string, 0, len(options))
+	for _, option := range enabledOptions(options) {
+		keys = append(keys, option.Key)
+	}
+	return keys
+}
+
+func (c *Catalog) addOn(key string) (AddOnType, bool) {
+	for _, addOn := range c.AddOns {
+		if addOn.Key == key {
+			return addOn, true
+		}
+	}
+	return AddOnType{}, false
+}
+
+// addOnGroups lists the enabled options of every add-on of a tier, leaving
+// out how they are priced
+func (c *Catalog) addOnGroups(tier string) []CatalogGroup {
+	groups := []CatalogGroup{}
+	for _, addOn := range c.AddOns {
+		if tier == "" || addOn.Tier == tier {
+			groups = append(groups, CatalogGroup{Key: addOn.Key, Label: addOn.Label, Tier: addOn.Tier, None: addOn.None, Options: enabledOptions(addOn.Options)})
+		}
+	}
+	return groups
+}
+
+// quantities returns the enabled quantities in ascending order
+func (c *Catalog) quantities() []int {
+	quantities := make([]int, 0, len(c.Quantities))
+	for _, key := range enabledKeys(c.Quantities) {
+		quantity, _ := strconv.Atoi(key)
+		quantities = append(quantities, quantity)
+	}
+	sort.Ints(quantities)
+	return quantities
+}
+
+// catalogFromRows builds a catalog from the catalog sheet tab, one option per
+// row: group, key, label, sort order, enabled, then for sizeCategories the
+// columns read by sizeSpecFromCells and for materials the gsm. Groups are materials,
+// sizeCategories, quantities, noOfColours, shapes, customerTiers or an add-on
+// key.
+//
+// Add-ons the built-in catalog doesn't know are described by an addOnType
+// row: addOnType, key, label, tier, table, row label, spec, single side
+// column, double side column, none option, formula. Discounts are discount rows, see
+// discountFromRow.
+func catalogFromRows(rows [][]interface{}) (*Catalog, error) {
+	defaults, err := parseCatalog(defaultCatalogJSON)
+	if err != nil {
+		return nil, err
+	}
+	catalog := new(Catalog)
+	lists := map[string]*[]CatalogOption{
+		"materials":      &catalog.Materials,
+		"sizeCategories": &catalog.SizeCategories,
+		"quantities":     &catalog.Quantities,
+		"noOfColours":    &catalog.NoOfColours,
+		"shapes":         &catalog.Shapes,
+		"customerTiers":  &catalog.CustomerTiers,
+	}
+	addOnTypes := make(map[string]AddOnType)
+	for i, row := range rows {
+		switch strings.ToLower(strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 0)))) {
+		case "addontype":
+			addOnType, err := addOnTypeFromRow(row)
+			if err != nil {
+				return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "%v", err)
+			}
+			addOnTypes[addOnType.Key] = addOnType
+		case "discount":
+			discount, err := discountFromRow(row)
+			if err != nil {
+				return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "%v", err)
+			}
+			catalog.Discounts = append(catalog.Discounts, discount)
+		}
+	}
+	addOns := make(map[string]int)
+	for i, row := range rows {
+		group := strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 0)))
+		if group == "" || strings.EqualFold(group, "addOnType") || strings.EqualFold(group, "discount") || (i == 0 && strings.EqualFold(group, "group")) {
+			continue
+		}
+		option := CatalogOption{
+			Key:   strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 1))),
+			Label: strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 2))),
+		}
+		if order := strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 3))); order != "" {
+			sortOrder, err := strconv.Atoi(order)
+			if err != nil {
+				return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "sort order %q is not a number", order)
+			}
+			option.SortOrder = sortOrder
+		} else {
+			option.SortOrder = i
+		}
+		if enabled := strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 4))); enabled != "" {
+			value, err := parseEnabled(enabled)
+			if err != nil {
+				return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "%v", err)
+			}
+			option.Enabled = &value
+		}
+		if group == "sizeCategories" {
+			cells := make([]string, 0, 6)
+			for index := 5; index < 11; index++ {
+				cells = append(cells, strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, index))))
+			}
+			if option.SizeSpec, err = sizeSpecFromCells(cells); err != nil {
+				return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "%v", err)
+			}
+		}
+		if gsm := strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, 5))); group == "materials" && gsm != "" {
+			if option.Gsm, err = strconv.ParseFloat(gsm, 64); err != nil || option.Gsm < 0 {
+				return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "gsm %q is not a number", gsm)
+			}
+		}
+		if list, ok := lists[group]; ok {
+			*list = append(*list, option)
+			continue
+		}
+		index, ok := addOns[group]
+		if !ok {
+			addOnType, ok := addOnTypes[group]
+			if !ok {
+				if addOnType, ok = defaults.addOn(group); !ok {
+					return nil, newPricingError(ErrMalformedRow, "catalog", i+1, "unknown group %q, add an addOnType row for new add-ons", group)
+				}
+			}
+			addOnType.Options = nil
+			catalog.AddOns = append(catalog.AddOns, addOnType)
+			index = len(catalog.AddOns) - 1
+			addOns[group] = index
+		}
+		catalog.AddOns[index].Options = append(catalog.AddOns[index].Options, option)
+	}
+	// the sheet has no rules, so it gets the built-in ones that fit it
+	for _, rule := range defaults.Rules {
+		if err := catalog.normaliseRule(&rule); err != nil {
+			log.Printf("Catalog sheet leaves out rule %s: %v", rule.ID, err)
+			continue
+		}
+		catalog.Rules = append(catalog.Rules, rule)
+	}
+	if len(catalog.Discounts) == 0 {
+		catalog.defaultDiscountsFor(defaults.Discounts)
+	}
+	catalog.BoxStyles = defaults.BoxStyles
+	catalog.Presses = defaults.Presses
+	catalog.Costing = defaults.Costing
+	if err := catalog.normalise(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+func addOnTypeFromRow(row []interface{}) (AddOnType, error) {
+	cell := func(index int) string {
+		return strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, index)))
+	}
+	addOnType := AddOnType{
+		Key:      cell(1),
+		Label:    cell(2),
+		Tier:     cell(3),
+		Table:    cell(4),
+		RowLabel: cell(5),
+		Spec:     cell(6),
+		None:     cell(9),
+		Formula:  cell(10),
+	}
+	if addOnType.Key == "" {
+		return addOnType, errors.New("addOnType row without a key")
+	}
+	var err error
+	if addOnType.Formula != "" && cell(7) == "" {
+		return addOnType, nil
+	}
+	if addOnType.SingleSideColumn, err = strconv.Atoi(cell(7)); err != nil {
+		return addOnType, fmt.Errorf("add-on %s single side column %q is not a number", addOnType.Key, cell(7))
+	}
+	if double := cell(8); double != "" {
+		if addOnType.DoubleSideColumn, err = strconv.Atoi(double); err != nil {
+			return addOnType, fmt.Errorf("add-on %s double side column %q is not a number", addOnType.Key, double)
+		}
+	}
+	return addOnType, nil
+}
+
+func cellOrEmpty(row []interface{}, index int) interface{} {
+	if index >= len(row) {
+		return ""
+	}
+	return row[index]
+}
+
+func parseEnabled(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "y", "1":
+		return true, nil
+	case "false", "no", "n", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("enabled %q is not yes or no", value)
+}
+
+// CatalogStore holds the current catalog and reloads it when its file or
+// sheet tab changes. A catalog that fails to load never replaces a good one.
+type CatalogStore struct {
+	name string
+	load func() ([]byte, error)
+	// parse turns what load read into a catalog
+	parse func([]byte) (*Catalog, error)
+
+	mu       sync.RWMutex
+	catalog  *Catalog
+	loadedAt time.Time
+	lastErr  error
+}
+
+type CatalogStatus struct {
+	Source    string    `json:"source"`
+	Version   string    `json:"version"`
+	LoadedAt  time.Time `json:"loadedAt"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// productCatalog is what the quotation form and validator offer. It starts
+// as the built-in catalog and is replaced at start up.
+var productCatalog = newStaticCatalogStore()
+
+func newStaticCatalogStore() *CatalogStore {
+	return newCatalogStore("built-in", func() ([]byte, error) { return defaultCatalogJSON, nil }, parseCatalog)
+}
+
+// newCatalogStore starts out with the built-in catalog, so there is always a
+// catalog to serve even before the first load succeeds
+func newCatalogStore(name string, load func() ([]byte, error), parse func([]byte) (*Catalog, error)) *CatalogStore {
+	catalog, err := parseCatalog(defaultCatalogJSON)
+	if err != nil {
+		panic(err)
+	}
+	catalog.version = catalogVersion(defaultCatalogJSON)
+	return &CatalogStore{
+		name:     name,
+		load:     load,
+		parse:    parse,
+		catalog:  catalog,
+		loadedAt: time.Now(),
+	}
+}
+
+func catalogVersion(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16]
+}
+
+// NewFileCatalogStore reads the catalog from a JSON file laid out like catalog.json
+func NewFileCatalogStore(path string) *CatalogStore {
+	return newCatalogStore(path, func() ([]byte, error) { return os.ReadFile(path) }, parseCatalog)
+}
+
+// NewSheetCatalogStore reads the catalog from the catalog range of a price source
+func NewSheetCatalogStore(src PriceSource, range_ string) *CatalogStore {
+	return newCatalogStore(range_,
+		func() ([]byte, error) {
+			rows, err := src.GetRange(range_)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(rows)
+		},
+		func(b []byte) (*Catalog, error) {
+			var rows [][]interface{}
+			if err := json.Unmarshal(b, &rows); err != nil {
+				return nil, err
+			}
+			return catalogFromRows(rows)
+		},
+	)
+}
+
+// newCatalogStoreFromEnv picks the catalog from CATALOG_SOURCE: "file" (the
+// default) reads CATALOG_PATH, falling back to the built-in catalog when the
+// file doesn't exist, "sheet" reads the catalog tab of the price source.
+func newCatalogStoreFromEnv(src PriceSource) (*CatalogStore, error) {
+	switch source := getEnvOrDefault("CATALOG_SOURCE", "file"); source {
+	case "file":
+		path := getEnvOrDefault("CATALOG_PATH", "catalog.json")
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			log.Printf("No catalog at %s, using the built-in catalog", path)
+			return newStaticCatalogStore(), nil
+		}
+		return NewFileCatalogStore(path), nil
+	case "sheet":
+		range_, _ := catalogSheetRange()
+		return NewSheetCatalogStore(src, range_), nil
+	default:
+		return nil, fmt.Errorf("unknown CATALOG_SOURCE %q, expected file or sheet", source)
+	}
+}
+
+// catalogSheetRange is the catalog tab, when CATALOG_SOURCE reads the catalog
+// from the price source
+func catalogSheetRange() (string, bool) {
+	if getEnvOrDefault("CATALOG_SOURCE", "file") != "sheet" {
+		return "", false
+	}
+	return getEnvOrDefault("CATALOG_RANGE", "catalog"), true
+}
+
+func (s *CatalogStore) Current() *Catalog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.catalog
+}
+
+// Version changes whenever the catalog content does
+func (s *CatalogStore) Version() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.catalog.version
+}
+
+// Reload reads the catalog again and reports whether it changed
+func (s *CatalogStore) Reload() (bool, error) {
+	b, err := s.load()
+	if err == nil {
+		var catalog *Catalog
+		if catalog, err = s.parse(b); err == nil {
+			catalog.version = catalogVersion(b)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			changed := catalog.version != s.catalog.version
+			s.catalog, s.loadedAt, s.lastErr = catalog, time.Now(), nil
+			return changed, nil
+		}
+	}
+	err = fmt.Errorf("unable to load catalog from %s: %w", s.name, err)
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+	return false, err
+}
+
+// Start checks for catalog changes every interval until stop is closed
+func (s *CatalogStore) Start(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				changed, err := s.Reload()
+				if err != nil {
+					log.Printf("Catalog reload failed, keeping the previous catalog: %v", err)
+				} else if changed {
+					log.Printf("Catalog reloaded from %s", s.name)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *CatalogStore) Status() CatalogStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := CatalogStatus{Source: s.name, Version: s.catalog.version, LoadedAt: s.loadedAt}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+func registerCatalogAdminRoutes(admin fiber.Router, store *CatalogStore) {
+	admin.Get("/catalog", func(c *fiber.Ctx) error {
+		return c.JSON(store.Status())
+	})
+	admin.Post("/catalog/reload", func(c *fiber.Ctx) error {
+		if _, err := store.Reload(); err != nil {
+			return err
+		}
+		return c.JSON(store.Status())
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CatalogResponse is what a quote form needs to offer only valid choices: the
+// enabled options of every list and the rules between them
+type CatalogResponse struct {
+	Version         string                  `json:"version"`
+	Materials       []CatalogOption         `json:"materials"`
+	SizeCategories  []CatalogOption         `json:"sizeCategories"`
+	Quantities      []CatalogOption         `json:"quantities"`
+	QuantityPricing QuantityPricingResponse `json:"quantityPricing"`
+	NoOfColours     []CatalogOption         `json:"noOfColours"`
+	Shapes          []CatalogOption         `json:"shapes"`
+	AddOns          []CatalogGroup          `json:"addOns"`
+	Rules           []CompatibilityRule     `json:"rules"`
+	CustomerTiers   []CatalogOption         `json:"customerTiers"`
+	BoxStyles       []BoxStyle              `json:"boxStyles"`
+}
+
+// QuantityPricingResponse tells clients whether quantities outside the
+// catalog list can be quoted
+type QuantityPricingResponse struct {
+	Mode        string `json:"mode"`
+	Model       string `json:"model,omitempty"`
+	MaxQuantity int    `json:"maxQuantity,omitempty"`
+}
+
+func newCatalogResponse(store *CatalogStore) *CatalogResponse {
+	catalog := store.Current()
+	pricing := QuantityPricingResponse{Mode: quantityPricing.Mode}
+	if quantityPricing.interpolates() {
+		pricing.Model, pricing.MaxQuantity = quantityPricing.Model, quantityPricing.MaxQuantity
+	}
+	return &CatalogResponse{
+		Version:         catalog.version,
+		Materials:       enabledOptions(catalog.Materials),
+		SizeCategories:  enabledOptions(catalog.SizeCategories),
+		Quantities:      enabledOptions(catalog.Quantities),
+		QuantityPricing: pricing,
+		NoOfColours:     enabledOptions(catalog.NoOfColours),
+		Shapes:          enabledOptions(catalog.Shapes),
+		AddOns:          catalog.addOnGroups(""),
+		Rules:           catalog.Rules,
+		CustomerTiers:   enabledOptions(catalog.CustomerTiers),
+		BoxStyles:       catalog.BoxStyles,
+	}
+}
+
+// registerCatalogAPIRoutes serves GET /api/v1/catalog. The ETag is a hash of
+// the body, so clients revalidate with If-None-Match and get a 304 until the
+// catalog changes.
+func registerCatalogAPIRoutes(api fiber.Router, store *CatalogStore) {
+	api.Get("/catalog", func(c *fiber.Ctx) error {
+		body, err := json.Marshal(newCatalogResponse(store))
+		if err != nil {
+			return err
+		}
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		return c.Send(body)
+	})
+}
+
+// etagMatches checks an If-None-Match header, which may list several tags or be *
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
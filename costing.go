@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Price engines. sheet looks every price up in the price sheets, cost works
+// it out from the catalog costs plus a margin.
+const (
+	PriceEngineSheet = "sheet"
+	PriceEngineCost  = "cost"
+)
+
+// priceEngine is set from the environment at start up
+var priceEngine = PriceEngineSheet
+
+// priceEngineFromEnv reads PRICE_ENGINE
+func priceEngineFromEnv() (string, error) {
+	engine := strings.ToLower(getEnvOrDefault("PRICE_ENGINE", PriceEngineSheet))
+	switch engine {
+	case PriceEngineSheet, PriceEngineCost:
+		return engine, nil
+	}
+	return engine, fmt.Errorf("PRICE_ENGINE must be %s or %s, got %q", PriceEngineSheet, PriceEngineCost, engine)
+}
+
+// Costing is what the cost engine adds to the costs
+type Costing struct {
+	// MarginPercent is the margin on the price, so 30 prices a RM70 cost at RM100
+	MarginPercent float64 `json:"marginPercent"`
+}
+
+// PressCosts are a press's costs for each side printed
+type PressCosts struct {
+	SetupCost          Money `json:"setupCost,omitempty"`
+	PlateCostPerColour Money `json:"plateCostPerColour,omitempty"`
+	// RunCostPer1000 is per 1000 impressions, one impression per sheet per side
+	RunCostPer1000 Money `json:"runCostPer1000,omitempty"`
+}
+
+// FinishingCost is the cost of an add-on option. An add-on type's cost is
+// the cost of its options that have none of their own.
+type FinishingCost struct {
+	SetupCost Money `json:"setupCost,omitempty"`
+	PerSheet  Money `json:"perSheet,omitempty"`
+	PerPiece  Money `json:"perPiece,omitempty"`
+}
+
+func (c *Catalog) normaliseCosting() error {
+	if c.Costing != nil && (c.Costing.MarginPercent < 0 || c.Costing.MarginPercent >= 100) {
+		return fmt.Errorf("invalid catalog: costing marginPercent %g must be from 0 to under 100", c.Costing.MarginPercent)
+	}
+	for _, press := range c.Presses {
+		if press.SetupCost < 0 || press.PlateCostPerColour < 0 || press.RunCostPer1000 < 0 {
+			return fmt.Errorf("invalid catalog: press %s has a negative cost", press.Key)
+		}
+	}
+	for _, material := range c.Materials {
+		for press, cost := range material.PaperCosts {
+			if _, ok := c.press(press); !ok {
+				return fmt.Errorf("invalid catalog: material %s has a paper cost for unknown press %q", material.Key, press)
+			}
+			if cost < 0 {
+				return fmt.Errorf("invalid catalog: material %s has a negative paper cost", material.Key)
+			}
+		}
+	}
+	for _, addOn := range c.AddOns {
+		if err := addOn.Cost.check(); err != nil {
+			return fmt.Errorf("invalid catalog: add-on %s %v", addOn.Key, err)
+		}
+		for _, option := range addOn.Options {
+			if err := option.Cost.check(); err != nil {
+				return fmt.Errorf("invalid catalog: add-on %s option %s %v", addOn.Key, option.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FinishingCost) check() error {
+	if f != nil && (f.SetupCost < 0 || f.PerSheet < 0 || f.PerPiece < 0) {
+		return errors.New("has a negative cost")
+	}
+	return nil
+}
+
+// cost is the cost of finishing quantity pieces printed on sheets
+func (f FinishingCost) cost(sheets int, quantity int) Money {
+	return f.SetupCost + f.PerSheet*Money(sheets) + f.PerPiece*Money(quantity)
+}
+
+// optionCost is the cost of an add-on option, or its add-on type's
+func (t AddOnType) optionCost(value string) (FinishingCost, bool) {
+	if option, ok := t.option(value); ok && option.Cost != nil {
+		return *option.Cost, true
+	}
+	if t.Cost != nil {
+		return *t.Cost, true
+	}
+	return FinishingCost{}, false
+}
+
+// colourCount is the number of colours of a noOfColours key, e.g. 4 for "4colours"
+func colourCount(key string) int {
+	end := strings.IndexFunc(key, func(r rune) bool { return !unicode.IsDigit(r) })
+	if end < 0 {
+		end = len(key)
+	}
+	colours, _ := strconv.Atoi(key[:end])
+	return colours
+}
+
+// price marks cost up to the margin
+func (c Costing) price(cost Money, rounding MoneyRounding) Money {
+	return rounding.fromRinggit(cost.Ringgit() / (1 - c.MarginPercent/100))
+}
+
+// costQuotation works out the pricing of each quantity from the catalog costs
+func (q *Quotation) costQuotation(catalog *Catalog) []*Pricing {
+	pricings := make([]*Pricing, 0, len(q.Quantity))
+	for _, quantity := range q.Quantity {
+		pricings = append(pricings, q.costPricing(catalog, quantity))
+	}
+	return pricings
+}
+
+func (q *Quotation) costPricing(catalog *Catalog, quantity int) *Pricing {
+	pricing := q.newPricing(catalog, quantity)
+	costing := Costing{}
+	if catalog.Costing != nil {
+		costing = *catalog.Costing
+	}
+	// priced marks a cost up, unavailable when it couldn't be worked out
+	priced := func(kind string, label string, option string, cost Money, reason string) LineItem {
+		item := LineItem{Kind: kind, Label: label, Option: option, Availability: available()}
+		if reason != "" {
+			item.Availability = unavailable(reason)
+			return item
+		}
+		item.Price = costing.price(cost, moneyRounding)
+		return item
+	}
+	width, height := q.blankSize(catalog)
+	press, ok := catalog.pressFor(q.SizeCategory, quantity)
+	if !ok {
+		pricing.addLineItem(priced(LineItemPrinting, "Printing", "", 0, fmt.Sprintf("no press takes %d pcs of %s", quantity, q.SizeCategory)))
+		return pricing
+	}
+	imposition, err := press.impose(width, height, quantity)
+	if err != nil {
+		pricing.addLineItem(priced(LineItemPrinting, "Printing", "", 0, fmt.Sprintf("%s has no imposition on %s: %v", q.SizeCategory, press.Label, err)))
+		return pricing
+	}
+	sheets := imposition.Sheets
+	colours := colourCount(q.NoOfColours)
+	// one side: plates for each colour and an impression per sheet, nothing without colour
+	sideCost := func() Money {
+		if colours == 0 {
+			return 0
+		}
+		return press.PlateCostPerColour*Money(colours) + moneyRounding.fromRinggit(press.RunCostPer1000.Ringgit()*float64(sheets)/1000)
+	}
+	material, _ := findOption(catalog.Materials, q.Material)
+	if paper, ok := material.PaperCosts[press.Key]; ok {
+		pricing.addLineItem(priced(LineItemPrinting, "Printing", "", press.SetupCost+paper*Money(sheets)+sideCost(), ""))
+	} else {
+		pricing.addLineItem(priced(LineItemPrinting, "Printing", "", 0, fmt.Sprintf("%s has no paper cost on %s", q.Material, press.Label)))
+	}
+	finish := func(tier string) {
+		for _, selected := range q.selectedAddOns(catalog, tier) {
+			label, option := selected.addOnType.lineItemLabel(selected.option)
+			cost, ok := selected.addOnType.optionCost(selected.option)
+			if !ok && selected.addOnType.formulaFor(selected.option) != nil {
+				// a formula is already a price, so it isn't marked up
+				pricing.addLineItem(q.formulaLineItem(catalog, tier, selected, quantity))
+				continue
+			}
+			reason := ""
+			if !ok {
+				reason = fmt.Sprintf("%s has no cost", selected.addOnType.summary(selected.option))
+			}
+			pricing.addLineItem(priced(addOnLineItemKind(tier), label, option, cost.cost(sheets, quantity), reason))
+		}
+	}
+	finish(AddOnTierPrimary)
+	finish(AddOnTierSecondary)
+	if q.printsBothSides() {
+		pricing.addLineItem(priced(LineItemAnotherSidePrinting, "printing another side", "", sideCost(), ""))
+		finish(AddOnTierThird)
+	}
+	return pricing
+}
+
+// PriceComparison is one catalog combination priced by both engines. A price
+// is nil when that engine can't quote it.
+type PriceComparison struct {
+	Material     string `json:"material"`
+	SizeCategory string `json:"sizeCategory"`
+	NoOfColours  string `json:"noOfColours"`
+	IsDoubleSide bool   `json:"isDoubleSide"`
+	// AddOn and Option name the add-on compared, blank for the printing
+	AddOn    string `json:"addOn,omitempty"`
+	Option   string `json:"option,omitempty"`
+	Quantity int    `json:"quantity"`
+
+	SheetPrice *Money `json:"sheetPrice"`
+	CostPrice  *Money `json:"costPrice"`
+	// Difference is the cost price less the sheet price, DifferencePercent
+	// that as a percentage of the sheet price to one decimal place
+	Difference        *Money   `json:"difference"`
+	DifferencePercent *float64 `json:"differencePercent"`
+}
+
+// compareQuotation prices the line items of kinds in q by both engines
+func compareQuotation(q *Quotation, tables priceTables, catalog *Catalog, kinds ...string) ([]PriceComparison, error) {
+	sum := func(pricing *Pricing) *Money {
+		var total Money
+		for _, item := range pricing.LineItems {
+			if indexOf(kinds, item.Kind) < 0 {
+				continue
+			}
+			if !item.Available {
+				return nil
+			}
+			total += item.Price
+		}
+		return &total
+	}
+	sheetPricings, err := q.sheetPricings(tables, catalog)
+	// a combination the sheet has no price for at all is no sheet price,
+	// anything else is a failure of the sheet itself
+	if errors.Is(err, ErrPriceNotAvailable) {
+		sheetPricings, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var comparisons []PriceComparison
+	for _, costed := range q.costQuotation(catalog) {
+		comparison := PriceComparison{Material: q.Material, SizeCategory: q.SizeCategory, NoOfColours: q.NoOfColours, IsDoubleSide: q.IsDoubleSide, Quantity: costed.Quantity, CostPrice: sum(costed)}
+		for _, pricing := range sheetPricings {
+			if pricing.Quantity == costed.Quantity {
+				comparison.SheetPrice = sum(pricing)
+			}
+		}
+		if comparison.SheetPrice != nil && comparison.CostPrice != nil {
+			difference := *comparison.CostPrice - *comparison.SheetPrice
+			comparison.Difference = &difference
+			if *comparison.SheetPrice != 0 {
+				percent := math.Round(float64(difference)/float64(*comparison.SheetPrice)*1000) / 10
+				comparison.DifferencePercent = &percent
+			}
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons, nil
+}
+
+// comparePrices prices every catalog combination by both engines: the
+// printing for each material, size, colours and sides, then each add-on
+// option on each size. Combinations the rules don't allow are left out.
+func comparePrices(ctx context.Context, src PriceSource, catalog *Catalog) ([]PriceComparison, error) {
+	ranges := []string{printingRange, anotherSideRange}
+	for _, addOn := range catalog.AddOns {
+		// an add-on priced by formula has no table to fetch
+		if addOn.Formula == "" {
+			ranges = append(ranges, addOn.Table)
+		}
+	}
+	tables, err := fetchPriceTables(ctx, src, uniqueRanges(ranges))
+	if err != nil {
+		return nil, err
+	}
+	materials := enabledOptions(catalog.Materials)
+	colours := enabledOptions(catalog.NoOfColours)
+	quantities := catalog.quantities()
+	comparisons := []PriceComparison{}
+	for _, size := range enabledOptions(catalog.SizeCategories) {
+		for _, material := range materials {
+			for _, colour := range colours {
+				for _, doubleSide := range []bool{false, true} {
+					q := &Quotation{SizeCategory: size.Key, Material: material.Key, NoOfColours: colour.Key, IsDoubleSide: doubleSide, Quantity: quantities, AddOns: []SelectedAddOn{}}
+					if q.validate(catalog) != nil {
+						continue
+					}
+					q.AddOns = nil
+					printing, err := compareQuotation(q, tables, catalog, LineItemPrinting, LineItemAnotherSidePrinting)
+					if err != nil {
+						return nil, err
+					}
+					comparisons = append(comparisons, printing...)
+				}
+			}
+		}
+		if len(materials) == 0 || len(colours) == 0 {
+			continue
+		}
+		// add-ons on the first material and the most colours, both sides for the other side's
+		for _, addOn := range catalog.AddOns {
+			for _, option := range enabledOptions(addOn.Options) {
+				if option.Key == addOn.None {
+					continue
+				}
+				q := &Quotation{SizeCategory: size.Key, Material: materials[0].Key, NoOfColours: colours[len(colours)-1].Key, IsDoubleSide: addOn.Tier == AddOnTierThird, Quantity: quantities, AddOns: []SelectedAddOn{{Type: addOn.Key, Option: option.Key}}}
+				if q.validate(catalog) != nil {
+					continue
+				}
+				// only the add-on compared, not what the rules imply with it
+				q.AddOns = []SelectedAddOn{{Type: addOn.Key, Option: option.Key}}
+				addOnComparisons, err := compareQuotation(q, tables, catalog, addOnLineItemKind(addOn.Tier))
+				if err != nil {
+					return nil, err
+				}
+				for _, comparison := range addOnComparisons {
+					comparison.AddOn, comparison.Option = addOn.Key, option.Key
+					comparisons = append(comparisons, comparison)
+				}
+			}
+		}
+	}
+	return comparisons, nil
+}
+
+// registerPriceComparisonRoutes serves GET /admin/pricing/compare, as JSON or
+// with ?format=csv as a spreadsheet
+func registerPriceComparisonRoutes(admin fiber.Router, src PriceSource, store *CatalogStore) {
+	admin.Get("/pricing/compare", func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), time.Minute)
+		defer cancel()
+		comparisons, err := comparePrices(ctx, src, store.Current())
+		if err != nil {
+			return err
+		}
+		if c.Query("format") != "csv" {
+			return c.JSON(fiber.Map{"engine": priceEngine, "comparisons": comparisons})
+		}
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.Write([]string{"material", "size", "colours", "double side", "add-on", "option", "quantity", "sheet price", "cost price", "difference", "difference %"})
+		optional := func(m *Money) string {
+			if m == nil {
+				return ""
+			}
+			return m.String()
+		}
+		for _, comparison := range comparisons {
+			percent := ""
+			if comparison.DifferencePercent != nil {
+				percent = strconv.FormatFloat(*comparison.DifferencePercent, 'f', 1, 64)
+			}
+			w.Write([]string{comparison.Material, comparison.SizeCategory, comparison.NoOfColours, strconv.FormatBool(comparison.IsDoubleSide), comparison.AddOn, comparison.Option, strconv.Itoa(comparison.Quantity), optional(comparison.SheetPrice), optional(comparison.CostPrice), optional(comparison.Difference), percent})
+		}
+		w.Flush()
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="price-comparison.csv"`)
+		return c.SendString(b.String())
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscountRule takes an amount off the total of each quantity it applies to,
+// shown as its own line item. Discounts are defined under "discounts" in the
+// catalog, or as discount rows of the catalog sheet tab.
+type DiscountRule struct {
+	ID string `json:"id"`
+	// Label is the line item label, e.g. "readied size shaped"
+	Label string `json:"label"`
+	// Flat takes a fixed amount off, Percent a percentage of the total so
+	// far. A rule has one or the other.
+	Flat    Money   `json:"flat,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	// When picks quotations by field, as the compatibility rules do, e.g.
+	// {"readiedSize": "true", "sizeCategory": "A4"} or {"customerTier": "trade"}
+	When map[string]string `json:"when,omitempty"`
+	// MinQuantity and MaxQuantity are the quantity tier, 0 for no limit
+	MinQuantity int `json:"minQuantity,omitempty"`
+	MaxQuantity int `json:"maxQuantity,omitempty"`
+	// ValidFrom and ValidTo are the first and last day the discount is
+	// given, e.g. "2026-12-31", in the server's time zone
+	ValidFrom string `json:"validFrom,omitempty"`
+	ValidTo   string `json:"validTo,omitempty"`
+	// Discounts are taken off in ascending Priority. Once an Exclusive
+	// discount is taken off, no discount after it is.
+	Priority  int  `json:"priority,omitempty"`
+	Exclusive bool `json:"exclusive,omitempty"`
+
+	validFrom time.Time
+	validTo   time.Time
+}
+
+const discountDateLayout = "2006-01-02"
+
+// normaliseDiscounts checks every discount and sorts them into the order
+// they are taken off
+func (c *Catalog) normaliseDiscounts() error {
+	seen := make(map[string]bool, len(c.Discounts))
+	for i := range c.Discounts {
+		discount := &c.Discounts[i]
+		if discount.ID == "" {
+			return fmt.Errorf("invalid catalog: discount %d has no id", i+1)
+		}
+		if seen[discount.ID] {
+			return fmt.Errorf("invalid catalog: discount %s is listed more than once", discount.ID)
+		}
+		seen[discount.ID] = true
+		if err := c.normaliseDiscount(discount); err != nil {
+			return fmt.Errorf("invalid catalog: discount %s: %w", discount.ID, err)
+		}
+	}
+	sort.SliceStable(c.Discounts, func(i, j int) bool { return c.Discounts[i].Priority < c.Discounts[j].Priority })
+	return nil
+}
+
+func (c *Catalog) normaliseDiscount(discount *DiscountRule) error {
+	switch {
+	case discount.Flat < 0 || discount.Percent < 0:
+		return errors.New("discount can't be negative")
+	case discount.Flat > 0 && discount.Percent > 0:
+		return errors.New("has both flat and percent")
+	case discount.Flat == 0 && discount.Percent == 0:
+		return errors.New("has no flat or percent")
+	case discount.Percent > 100:
+		return fmt.Errorf("percent %g is over 100", discount.Percent)
+	}
+	for field, value := range discount.When {
+		if err := c.checkRuleValue(field, value); err != nil {
+			return err
+		}
+	}
+	if discount.MinQuantity < 0 || discount.MaxQuantity < 0 || (discount.MaxQuantity > 0 && discount.MinQuantity > discount.MaxQuantity) {
+		return fmt.Errorf("quantity tier %d to %d is invalid", discount.MinQuantity, discount.MaxQuantity)
+	}
+	var err error
+	if discount.ValidFrom != "" {
+		if discount.validFrom, err = time.ParseInLocation(discountDateLayout, discount.ValidFrom, time.Local); err != nil {
+			return fmt.Errorf("validFrom %q is not a date like 2026-12-31", discount.ValidFrom)
+		}
+	}
+	if discount.ValidTo != "" {
+		if discount.validTo, err = time.ParseInLocation(discountDateLayout, discount.ValidTo, time.Local); err != nil {
+			return fmt.Errorf("validTo %q is not a date like 2026-12-31", discount.ValidTo)
+		}
+		if !discount.validFrom.IsZero() && discount.validTo.Before(discount.validFrom) {
+			return fmt.Errorf("validTo %s is before validFrom %s", discount.ValidTo, discount.ValidFrom)
+		}
+	}
+	if discount.Label == "" {
+		discount.Label = discount.ID
+	}
+	return nil
+}
+
+// validOn is whether the discount is given on the day of t
+func (d DiscountRule) validOn(t time.Time) bool {
+	if !d.validFrom.IsZero() && t.Before(d.validFrom) {
+		return false
+	}
+	// valid to the end of its last day
+	return d.validTo.IsZero() || t.Before(d.validTo.AddDate(0, 0, 1))
+}
+
+func (d DiscountRule) appliesTo(q *Quotation, catalog *Catalog, quantity int) bool {
+	if quantity < d.MinQuantity || (d.MaxQuantity > 0 && quantity > d.MaxQuantity) {
+		return false
+	}
+	for field, value := range d.When {
+		if !q.fieldIs(catalog, field, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// amountOff is how much the discount takes off total, never more than total
+func (d DiscountRule) amountOff(total Money, rounding MoneyRounding) Money {
+	amount := d.Flat
+	if d.Percent > 0 {
+		amount = rounding.fromRinggit(total.Ringgit() * d.Percent / 100)
+	}
+	if amount > total {
+		return total
+	}
+	return amount
+}
+
+// applyDiscounts takes every discount that applies on the day of at off
+// each quantity, one line item per discount
+func (q *Quotation) applyDiscounts(pricings []*Pricing, catalog *Catalog, at time.Time) {
+	for _, pricing := range pricings {
+		for _, discount := range catalog.Discounts {
+			if !discount.validOn(at) || !discount.appliesTo(q, catalog, pricing.Quantity) {
+				continue
+			}
+			amount := discount.amountOff(pricing.Total, moneyRounding)
+			if amount <= 0 {
+				continue
+			}
+			pricing.addLineItem(LineItem{Kind: LineItemDiscount, Label: discount.Label, Discount: discount.ID, Price: -amount, Availability: available()})
+			if discount.Exclusive {
+				break
+			}
+		}
+	}
+}
+
+// discountFromRow reads a discount row of the catalog sheet tab: discount,
+// id, label, flat, percent, when, min quantity, max quantity, valid from,
+// valid to, priority, exclusive. When is written "field=value; field=value".
+func discountFromRow(row []interface{}) (DiscountRule, error) {
+	cell := func(index int) string {
+		return strings.TrimSpace(fmt.Sprint(cellOrEmpty(row, index)))
+	}
+	discount := DiscountRule{ID: cell(1), Label: cell(2), ValidFrom: cell(8), ValidTo: cell(9)}
+	if discount.ID == "" {
+		return discount, errors.New("discount row without an id")
+	}
+	var err error
+	if flat := cell(3); flat != "" {
+		if discount.Flat, err = ParseMoney(flat); err != nil {
+			return discount, fmt.Errorf("discount %s flat: %v", discount.ID, err)
+		}
+	}
+	if percent := strings.TrimSuffix(cell(4), "%"); percent != "" {
+		if discount.Percent, err = strconv.ParseFloat(strings.TrimSpace(percent), 64); err != nil {
+			return discount, fmt.Errorf("discount %s percent %q is not a number", discount.ID, cell(4))
+		}
+	}
+	if when := cell(5); when != "" {
+		discount.When = make(map[string]string)
+		for _, condition := range strings.Split(when, ";") {
+			field, value, ok := strings.Cut(condition, "=")
+			if !ok {
+				return discount, fmt.Errorf("discount %s condition %q is not field=value", discount.ID, strings.TrimSpace(condition))
+			}
+			discount.When[strings.TrimSpace(field)] = strings.TrimSpace(value)
+		}
+	}
+	for _, number := range []struct {
+		index int
+		name  string
+		value *int
+	}{{6, "min quantity", &discount.MinQuantity}, {7, "max quantity", &discount.MaxQuantity}, {10, "priority", &discount.Priority}} {
+		if text := cell(number.index); text != "" {
+			if *number.value, err = strconv.Atoi(text); err != nil {
+				return discount, fmt.Errorf("discount %s %s %q is not a number", discount.ID, number.name, text)
+			}
+		}
+	}
+	if exclusive := cell(11); exclusive != "" {
+		if discount.Exclusive, err = parseEnabled(exclusive); err != nil {
+			return discount, fmt.Errorf("discount %s exclusive %q is not yes or no", discount.ID, exclusive)
+		}
+	}
+	return discount, nil
+}
+
+// defaultDiscountsFor keeps the built-in discounts that fit a catalog read
+// from the sheet tab, for a tab without discount rows of its own
+func (c *Catalog) defaultDiscountsFor(defaults []DiscountRule) {
+	for _, discount := range defaults {
+		if err := c.normaliseDiscount(&discount); err != nil {
+			log.Printf("Catalog sheet leaves out discount %s: %v", discount.ID, err)
+			continue
+		}
+		c.Discounts = append(c.Discounts, discount)
+	}
+}
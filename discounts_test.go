@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// discountedLineItems applies discounts to a quotation whose every quantity
+// costs RM200, and returns the discount line items of each quantity
+func discountedLineItems(t *testing.T, q *Quotation, discounts []DiscountRule, at time.Time) map[int][]string {
+	t.Helper()
+	catalog := testCatalog(t)
+	catalog.Discounts = discounts
+	if err := catalog.normaliseDiscounts(); err != nil {
+		t.Fatal(err)
+	}
+	var pricings []*Pricing
+	for _, quantity := range q.Quantity {
+		pricing := q.newPricing(catalog, quantity)
+		pricing.addLineItem(LineItem{Kind: LineItemPrinting, Label: "Printing", Price: 200 * Ringgit, Availability: available()})
+		pricings = append(pricings, pricing)
+	}
+	q.applyDiscounts(pricings, catalog, at)
+	discounted := make(map[int][]string, len(pricings))
+	for _, pricing := range pricings {
+		discounted[pricing.Quantity] = []string{}
+		for _, item := range pricing.LineItems {
+			if item.Kind == LineItemDiscount {
+				discounted[pricing.Quantity] = append(discounted[pricing.Quantity], item.Discount+" "+item.Price.String())
+			}
+		}
+	}
+	return discounted
+}
+
+func TestApplyDiscounts(t *testing.T) {
+	day := time.Date(2026, 10, 15, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name      string
+		modify    func(q *Quotation)
+		discounts []DiscountRule
+		want      map[int][]string
+	}{
+		{
+			name:      "flat",
+			discounts: []DiscountRule{{ID: "a", Flat: 15 * Ringgit}},
+			want:      map[int][]string{100: {"a -15.00"}, 200: {"a -15.00"}},
+		},
+		{
+			name:      "percent",
+			discounts: []DiscountRule{{ID: "a", Percent: 12.5}},
+			want:      map[int][]string{100: {"a -25.00"}, 200: {"a -25.00"}},
+		},
+		{
+			name:      "never more than the total",
+			discounts: []DiscountRule{{ID: "a", Flat: 250 * Ringgit}},
+			want:      map[int][]string{100: {"a -200.00"}, 200: {"a -200.00"}},
+		},
+		{
+			name:      "quantity tier",
+			discounts: []DiscountRule{{ID: "a", Flat: 10 * Ringgit, MinQuantity: 150}, {ID: "b", Flat: 5 * Ringgit, MaxQuantity: 100}},
+			want:      map[int][]string{100: {"b -5.00"}, 200: {"a -10.00"}},
+		},
+		{
+			name:      "when",
+			modify:    func(q *Quotation) { q.ReadiedSize, q.CustomerTier = true, "trade" },
+			discounts: []DiscountRule{{ID: "a", Flat: 10 * Ringgit, When: map[string]string{"readiedSize": "true", "sizeCategory": "A4"}}, {ID: "b", Flat: 5 * Ringgit, When: map[string]string{"customerTier": "retail"}}},
+			want:      map[int][]string{100: {"a -10.00"}, 200: {"a -10.00"}},
+		},
+		{
+			name:      "size category covers its sizes",
+			modify:    func(q *Quotation) { q.SizeCategory = "A4+" },
+			discounts: []DiscountRule{{ID: "a", Flat: 10 * Ringgit, When: map[string]string{"sizeCategory": "A4"}}},
+			want:      map[int][]string{100: {"a -10.00"}, 200: {"a -10.00"}},
+		},
+		{
+			name: "valid dates",
+			discounts: []DiscountRule{
+				{ID: "ended", Flat: 1 * Ringgit, ValidTo: "2026-10-14"},
+				{ID: "lastDay", Flat: 2 * Ringgit, ValidTo: "2026-10-15"},
+				{ID: "firstDay", Flat: 3 * Ringgit, ValidFrom: "2026-10-15"},
+				{ID: "notYet", Flat: 4 * Ringgit, ValidFrom: "2026-10-16"},
+			},
+			want: map[int][]string{100: {"lastDay -2.00", "firstDay -3.00"}, 200: {"lastDay -2.00", "firstDay -3.00"}},
+		},
+		{
+			name: "priority and percent of the total so far",
+			discounts: []DiscountRule{
+				{ID: "percent", Percent: 10, Priority: 20},
+				{ID: "flat", Flat: 100 * Ringgit, Priority: 10},
+			},
+			want: map[int][]string{100: {"flat -100.00", "percent -10.00"}, 200: {"flat -100.00", "percent -10.00"}},
+		},
+		{
+			name: "exclusive",
+			discounts: []DiscountRule{
+				{ID: "later", Flat: 5 * Ringgit, Priority: 20},
+				{ID: "exclusive", Flat: 10 * Ringgit, Priority: 10, Exclusive: true, MinQuantity: 200},
+			},
+			want: map[int][]string{100: {"later -5.00"}, 200: {"exclusive -10.00"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQuotation()
+			if tt.modify != nil {
+				tt.modify(q)
+			}
+			if got := discountedLineItems(t, q, tt.discounts, day); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormaliseDiscounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount DiscountRule
+	}{
+		{"no id", DiscountRule{Flat: Ringgit}},
+		{"negative", DiscountRule{ID: "a", Flat: -Ringgit}},
+		{"flat and percent", DiscountRule{ID: "a", Flat: Ringgit, Percent: 10}},
+		{"no amount", DiscountRule{ID: "a"}},
+		{"over 100 percent", DiscountRule{ID: "a", Percent: 101}},
+		{"unknown field", DiscountRule{ID: "a", Flat: Ringgit, When: map[string]string{"paper": "art card 350gsm"}}},
+		{"unknown tier", DiscountRule{ID: "a", Flat: Ringgit, When: map[string]string{"customerTier": "vip"}}},
+		{"quantity tier", DiscountRule{ID: "a", Flat: Ringgit, MinQuantity: 500, MaxQuantity: 100}},
+		{"bad date", DiscountRule{ID: "a", Flat: Ringgit, ValidFrom: "15/10/2026"}},
+		{"ends before it starts", DiscountRule{ID: "a", Flat: Ringgit, ValidFrom: "2026-10-15", ValidTo: "2026-10-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := testCatalog(t)
+			catalog.Discounts = []DiscountRule{tt.discount}
+			if err := catalog.normaliseDiscounts(); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestDiscountFromRow(t *testing.T) {
+	discount, err := discountFromRow([]interface{}{"discount", "trade", "trade price", "", "7.5%", "customerTier=trade; readiedSize=true", "500", "", "2026-01-01", "2026-12-31", "5", "yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DiscountRule{ID: "trade", Label: "trade price", Percent: 7.5, When: map[string]string{"customerTier": "trade", "readiedSize": "true"}, MinQuantity: 500, ValidFrom: "2026-01-01", ValidTo: "2026-12-31", Priority: 5, Exclusive: true}
+	if !reflect.DeepEqual(discount, want) {
+		t.Errorf("got %+v, want %+v", discount, want)
+	}
+	for _, row := range [][]interface{}{
+		{"discount", "", "no id", "10"},
+		{"discount", "a", "", "ten"},
+		{"discount", "a", "", "", "ten"},
+		{"discount", "a", "", "10", "", "customerTier"},
+		{"discount", "a", "", "10", "", "", "many"},
+		{"discount", "a", "", "10", "", "", "", "", "", "", "", "perhaps"},
+	} {
+		if _, err := discountFromRow(row); err == nil {
+			t.Errorf("%v: no error", row)
+		}
+	}
+}
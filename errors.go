@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Kinds of pricing failure. Every error returned from a PriceSource or a
+// pricing method wraps one of these, so callers can test them with errors.Is.
+var (
+	ErrSourceUnavailable = errors.New("price source unavailable")
+	ErrRangeMissing      = errors.New("price range missing")
+	ErrMalformedRow      = errors.New("malformed price row")
+	ErrPriceNotAvailable = errors.New("price not available")
+)
+
+// PricingError records which range, and row when known, a pricing failure came from
+type PricingError struct {
+	Kind  error
+	Range string
+	Row   int // 1-based sheet row, 0 when the whole range is affected
+	Err   error
+}
+
+func (e *PricingError) Error() string {
+	location := e.Range
+	if e.Row > 0 {
+		location = fmt.Sprintf("%s row %d", e.Range, e.Row)
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Kind, location)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Kind, location, e.Err)
+}
+
+func (e *PricingError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func newPricingError(kind error, range_ string, row int, format string, args ...interface{}) *PricingError {
+	return &PricingError{Kind: kind, Range: range_, Row: row, Err: fmt.Errorf(format, args...)}
+}
+
+// cellString returns the cell at index of a sheet row, rowIndex being its
+// position in the range values
+func cellString(row []interface{}, index int, range_ string, rowIndex int) (string, error) {
+	if index >= len(row) {
+		return "", newPricingError(ErrMalformedRow, range_, rowIndex+1, "missing column %d", index+1)
+	}
+	value, ok := row[index].(string)
+	if !ok {
+		return "", newPricingError(ErrMalformedRow, range_, rowIndex+1, "column %d is %T, expected text", index+1, row[index])
+	}
+	return value, nil
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorHandler turns any error returned by a route into a JSON body and a status
+// code, so a failed quotation never takes the server down with it.
+func errorHandler(c *fiber.Ctx, err error) error {
+	var validationErrors ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return validationErrorResponse(c, validationErrors)
+	}
+
+	status, code := fiber.StatusInternalServerError, "internal_error"
+	var fiberError *fiber.Error
+	switch {
+	case errors.Is(err, ErrPriceNotAvailable):
+		status, code = fiber.StatusUnprocessableEntity, "price_not_available"
+	case errors.Is(err, ErrSourceUnavailable):
+		status, code = fiber.StatusServiceUnavailable, "source_unavailable"
+	case errors.Is(err, ErrRangeMissing):
+		status, code = fiber.StatusBadGateway, "range_missing"
+	case errors.Is(err, ErrMalformedRow):
+		status, code = fiber.StatusBadGateway, "malformed_row"
+	case errors.As(err, &fiberError):
+		status, code = fiberError.Code, "request_error"
+	}
+	message := err.Error()
+	if status >= fiber.StatusInternalServerError {
+		// the details of a server side failure, such as Sheets, OAuth or
+		// database errors, stay in the log
+		log.Printf("%s %s: %v", c.Method(), c.Path(), err)
+		message = utils.StatusMessage(status)
+	}
+	return c.Status(status).JSON(fiber.Map{"error": errorBody{Code: code, Message: message}})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestErrorHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{"price not available", newPricingError(ErrPriceNotAvailable, printingRange, 0, "no printing price for A5"), 422, `{"error":{"code":"price_not_available","message":"price not available: printing_raw: no printing price for A5"}}`},
+		{"source unavailable", &PricingError{Kind: ErrSourceUnavailable, Range: printingRange, Err: errors.New("oauth2: token expired")}, 503, `{"error":{"code":"source_unavailable","message":"Service Unavailable"}}`},
+		{"malformed row", newPricingError(ErrMalformedRow, printingRange, 4, "column 6 is float64"), 502, `{"error":{"code":"malformed_row","message":"Bad Gateway"}}`},
+		{"internal", errors.New("bbolt: database not open"), 500, `{"error":{"code":"internal_error","message":"Internal Server Error"}}`},
+		{"request", fiber.NewError(fiber.StatusBadRequest, "invalid JSON"), 400, `{"error":{"code":"request_error","message":"invalid JSON"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+			app.Get("/", func(c *fiber.Ctx) error { return tt.err })
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || strings.TrimSpace(string(body)) != tt.body {
+				t.Errorf("got %d %s, want %d %s", resp.StatusCode, body, tt.status, tt.body)
+			}
+		})
+	}
+}
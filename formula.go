@@ -0,0 +1,471 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A formula prices an add-on in ringgit from the quotation instead of a table
+// row, e.g. "40 + size.area_mm2 / 1000000 * 12 * quantity". Formulas are
+// arithmetic on numbers and the variables below, with + - * / and brackets,
+// comparisons (< <= > >= == !=, 1 for true and 0 for false) and the functions
+// min, max, ceil, floor, round and if(condition, then, else). Nothing else
+// can be reached from a formula.
+
+// formulaVariables are the variables a formula can use
+var formulaVariables = []string{
+	"quantity",
+	"sides",
+	"colours",
+	"size.area_mm2",
+	"size.width_mm",
+	"size.height_mm",
+	"material.gsm",
+	"option.area",
+}
+
+// Limits that keep a formula cheap to check and evaluate
+const (
+	maxFormulaLength = 500
+	maxFormulaDepth  = 32
+)
+
+// formulaFunctions are the functions with their number of arguments, -1 for
+// one or more
+var formulaFunctions = map[string]int{
+	"min":   -1,
+	"max":   -1,
+	"ceil":  1,
+	"floor": 1,
+	"round": 1,
+	"if":    3,
+}
+
+// FormulaError says what is wrong with a formula and where, counting columns from 1
+type FormulaError struct {
+	Formula string
+	Column  int
+	Message string
+}
+
+func (e *FormulaError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("formula %q: %s", e.Formula, e.Message)
+	}
+	return fmt.Sprintf("formula %q at column %d: %s", e.Formula, e.Column, e.Message)
+}
+
+type formula struct {
+	text string
+	root formulaNode
+}
+
+type formulaNode interface {
+	eval(variables map[string]float64) (float64, error)
+}
+
+type formulaNumber float64
+
+type formulaVariable string
+
+type formulaUnary struct {
+	operand formulaNode
+}
+
+type formulaBinary struct {
+	operator    string
+	left, right formulaNode
+}
+
+type formulaCall struct {
+	name      string
+	arguments []formulaNode
+}
+
+type formulaToken struct {
+	text   string
+	column int
+}
+
+// parseFormula checks a formula and gets it ready to evaluate
+func parseFormula(text string) (*formula, error) {
+	if len(text) > maxFormulaLength {
+		return nil, &FormulaError{Formula: text, Message: fmt.Sprintf("is longer than %d characters", maxFormulaLength)}
+	}
+	tokens, err := formulaTokens(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &FormulaError{Formula: text, Message: "is empty"}
+	}
+	p := &formulaParser{text: text, tokens: tokens}
+	root, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.position < len(p.tokens) {
+		return nil, p.errorAt(p.tokens[p.position], "unexpected %q", p.tokens[p.position].text)
+	}
+	return &formula{text: text, root: root}, nil
+}
+
+func formulaTokens(text string) ([]formulaToken, error) {
+	var tokens []formulaToken
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsDigit(r) || r == '.':
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+		case strings.ContainsRune("<>=!", r) && i+1 < len(runes) && runes[i+1] == '=':
+			i += 2
+		case strings.ContainsRune("+-*/(),<>", r):
+			i++
+		default:
+			return nil, &FormulaError{Formula: text, Column: start + 1, Message: fmt.Sprintf("unexpected %q", string(r))}
+		}
+		tokens = append(tokens, formulaToken{text: string(runes[start:i]), column: start + 1})
+	}
+	return tokens, nil
+}
+
+type formulaParser struct {
+	text     string
+	tokens   []formulaToken
+	position int
+}
+
+func (p *formulaParser) errorAt(token formulaToken, format string, args ...interface{}) error {
+	return &FormulaError{Formula: p.text, Column: token.column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *formulaParser) errorAtEnd(format string, args ...interface{}) error {
+	return &FormulaError{Formula: p.text, Column: len([]rune(p.text)) + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *formulaParser) peek() (formulaToken, bool) {
+	if p.position >= len(p.tokens) {
+		return formulaToken{}, false
+	}
+	return p.tokens[p.position], true
+}
+
+// formulaPrecedence of the binary operators, higher binding tighter
+var formulaPrecedence = map[string]int{
+	"<": 1, "<=": 1, ">": 1, ">=": 1, "==": 1, "!=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3,
+}
+
+func (p *formulaParser) expression(depth int) (formulaNode, error) {
+	return p.binary(0, depth)
+}
+
+// binary parses operators that bind tighter than precedence
+func (p *formulaParser) binary(precedence int, depth int) (formulaNode, error) {
+	left, err := p.operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, ok := p.peek()
+		tokenPrecedence, isOperator := formulaPrecedence[token.text]
+		if !ok || !isOperator || tokenPrecedence <= precedence {
+			return left, nil
+		}
+		p.position++
+		right, err := p.binary(tokenPrecedence, depth)
+		if err != nil {
+			return nil, err
+		}
+		left = formulaBinary{operator: token.text, left: left, right: right}
+	}
+}
+
+func (p *formulaParser) operand(depth int) (formulaNode, error) {
+	if depth > maxFormulaDepth {
+		return nil, p.errorAtEnd("is nested more than %d deep", maxFormulaDepth)
+	}
+	token, ok := p.peek()
+	if !ok {
+		return nil, p.errorAtEnd("ends too soon")
+	}
+	p.position++
+	first, _ := utf8First(token.text)
+	switch {
+	case token.text == "-":
+		operand, err := p.operand(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return formulaUnary{operand}, nil
+	case token.text == "(":
+		inner, err := p.expression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := p.peek(); !ok || next.text != ")" {
+			return nil, p.errorAtEnd("has a ( without a )")
+		}
+		p.position++
+		return inner, nil
+	case unicode.IsDigit(first) || first == '.':
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, p.errorAt(token, "%q is not a number", token.text)
+		}
+		return formulaNumber(value), nil
+	case unicode.IsLetter(first) || first == '_':
+		if next, ok := p.peek(); ok && next.text == "(" {
+			return p.call(token, depth)
+		}
+		for _, name := range formulaVariables {
+			if token.text == name {
+				return formulaVariable(name), nil
+			}
+		}
+		return nil, p.errorAt(token, "unknown variable %q, expected one of %s", token.text, strings.Join(formulaVariables, ", "))
+	}
+	return nil, p.errorAt(token, "unexpected %q", token.text)
+}
+
+func (p *formulaParser) call(name formulaToken, depth int) (formulaNode, error) {
+	arity, ok := formulaFunctions[name.text]
+	if !ok {
+		return nil, p.errorAt(name, "unknown function %q", name.text)
+	}
+	p.position++ // (
+	call := formulaCall{name: name.text}
+	for {
+		argument, err := p.expression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		call.arguments = append(call.arguments, argument)
+		next, ok := p.peek()
+		if !ok {
+			return nil, p.errorAtEnd("has a ( without a )")
+		}
+		p.position++
+		if next.text == ")" {
+			break
+		}
+		if next.text != "," {
+			return nil, p.errorAt(next, "unexpected %q in %s()", next.text, name.text)
+		}
+	}
+	if arity >= 0 && len(call.arguments) != arity {
+		return nil, p.errorAt(name, "%s() takes %d arguments, not %d", name.text, arity, len(call.arguments))
+	}
+	return call, nil
+}
+
+func utf8First(s string) (rune, bool) {
+	for _, r := range s {
+		return r, true
+	}
+	return 0, false
+}
+
+func (n formulaNumber) eval(map[string]float64) (float64, error) {
+	return float64(n), nil
+}
+
+func (n formulaVariable) eval(variables map[string]float64) (float64, error) {
+	return variables[string(n)], nil
+}
+
+func (n formulaUnary) eval(variables map[string]float64) (float64, error) {
+	value, err := n.operand.eval(variables)
+	return -value, err
+}
+
+func (n formulaBinary) eval(variables map[string]float64) (float64, error) {
+	left, err := n.left.eval(variables)
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.eval(variables)
+	if err != nil {
+		return 0, err
+	}
+	truth := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch n.operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	case "<":
+		return truth(left < right), nil
+	case "<=":
+		return truth(left <= right), nil
+	case ">":
+		return truth(left > right), nil
+	case ">=":
+		return truth(left >= right), nil
+	case "==":
+		return truth(left == right), nil
+	}
+	return truth(left != right), nil
+}
+
+func (n formulaCall) eval(variables map[string]float64) (float64, error) {
+	if n.name == "if" {
+		condition, err := n.arguments[0].eval(variables)
+		if err != nil {
+			return 0, err
+		}
+		if condition != 0 {
+			return n.arguments[1].eval(variables)
+		}
+		return n.arguments[2].eval(variables)
+	}
+	values := make([]float64, len(n.arguments))
+	for i, argument := range n.arguments {
+		value, err := argument.eval(variables)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+	}
+	switch n.name {
+	case "ceil":
+		return math.Ceil(values[0]), nil
+	case "floor":
+		return math.Floor(values[0]), nil
+	case "round":
+		return math.Round(values[0]), nil
+	case "min":
+		result := values[0]
+		for _, value := range values[1:] {
+			result = math.Min(result, value)
+		}
+		return result, nil
+	}
+	result := values[0]
+	for _, value := range values[1:] {
+		result = math.Max(result, value)
+	}
+	return result, nil
+}
+
+// price evaluates the formula as an amount in ringgit
+func (f *formula) price(variables map[string]float64, rounding MoneyRounding) (Money, error) {
+	value, err := f.root.eval(variables)
+	if err != nil {
+		return 0, &FormulaError{Formula: f.text, Message: err.Error()}
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) || math.Abs(value) > 1e12 {
+		return 0, &FormulaError{Formula: f.text, Message: "is out of range"}
+	}
+	if value < 0 {
+		return 0, &FormulaError{Formula: f.text, Message: fmt.Sprintf("came to a negative price %g", value)}
+	}
+	return rounding.fromRinggit(value), nil
+}
+
+// formulaFor is the formula pricing an option, its own or its add-on's, or
+// nil when it is priced from the table
+func (t AddOnType) formulaFor(value string) *formula {
+	if option, ok := t.option(value); ok && option.parsedFormula != nil {
+		return option.parsedFormula
+	}
+	return t.parsedFormula
+}
+
+// formulaValues are the values of the formula variables for one quantity
+func (q *Quotation) formulaValues(catalog *Catalog, selected addOnSelection, quantity int) map[string]float64 {
+	width, height := q.blankSize(catalog)
+	sides := 1.0
+	if q.printsBothSides() {
+		sides = 2
+	}
+	material, _ := findOption(catalog.Materials, q.Material)
+	option, _ := selected.addOnType.option(selected.option)
+	return map[string]float64{
+		"quantity":       float64(quantity),
+		"sides":          sides,
+		"colours":        float64(colourCount(q.NoOfColours)),
+		"size.area_mm2":  width * height,
+		"size.width_mm":  width,
+		"size.height_mm": height,
+		"material.gsm":   material.Gsm,
+		"option.area":    option.Area,
+	}
+}
+
+// formulaPrice prices a selected add-on by its formula
+func (q *Quotation) formulaPrice(catalog *Catalog, selected addOnSelection, quantity int) (Money, error) {
+	return selected.addOnType.formulaFor(selected.option).price(q.formulaValues(catalog, selected, quantity), moneyRounding)
+}
+
+// formulaLineItem prices an add-on by its formula. A formula that fails at
+// this quantity is logged, and customers are only told the add-on is not
+// available, as they never see the formula.
+func (q *Quotation) formulaLineItem(catalog *Catalog, tier string, selected addOnSelection, quantity int) LineItem {
+	label, option := selected.addOnType.lineItemLabel(selected.option)
+	item := LineItem{Kind: addOnLineItemKind(tier), Label: label, Option: option, Availability: available()}
+	price, err := q.formulaPrice(catalog, selected, quantity)
+	if err != nil {
+		log.Printf("Unable to price %s for %d pcs: %v", item.name(), quantity, err)
+		item.Availability = unavailable(item.unavailableReason(quantity))
+	}
+	item.Price = price
+	return item
+}
+
+// parseFormulas parses every add-on formula once, so a catalog with a broken
+// one fails to load
+func (c *Catalog) parseFormulas() error {
+	for i := range c.AddOns {
+		addOn := &c.AddOns[i]
+		addOn.parsedFormula = nil
+		if addOn.Formula != "" {
+			f, err := parseFormula(addOn.Formula)
+			if err != nil {
+				return fmt.Errorf("invalid catalog: add-on %s %v", addOn.Key, err)
+			}
+			addOn.parsedFormula = f
+		}
+		for j := range addOn.Options {
+			option := &addOn.Options[j]
+			option.parsedFormula = nil
+			if option.Formula == "" {
+				continue
+			}
+			f, err := parseFormula(option.Formula)
+			if err != nil {
+				return fmt.Errorf("invalid catalog: add-on %s option %s %v", addOn.Key, option.Key, err)
+			}
+			option.parsedFormula = f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFormulaErrors(t *testing.T) {
+	tests := []struct {
+		formula string
+		column  int
+		message string
+	}{
+		{"", 0, "is empty"},
+		{strings.Repeat("1+", 300) + "1", 0, "is longer than 500 characters"},
+		{strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40), 82, "is nested more than 32 deep"},
+		{"quantity * foo", 12, `unknown variable "foo"`},
+		{"exec(1)", 1, `unknown function "exec"`},
+		{"os.Getenv(1)", 1, `unknown function "os.Getenv"`},
+		{"ceil(1, 2)", 1, "ceil() takes 1 arguments, not 2"},
+		{"if(quantity > 1, 2)", 1, "if() takes 3 arguments, not 2"},
+		{"quantity; 1", 9, `unexpected ";"`},
+		{`"quantity"`, 1, `unexpected "\""`},
+		{"(1 + 2", 7, "has a ( without a )"},
+		{"max(1, 2", 9, "has a ( without a )"},
+		{"1 2", 3, `unexpected "2"`},
+		{"1.2.3", 1, `"1.2.3" is not a number`},
+		{"quantity +", 11, "ends too soon"},
+		{"* 2", 1, `unexpected "*"`},
+	}
+	for _, tt := range tests {
+		_, err := parseFormula(tt.formula)
+		var formulaErr *FormulaError
+		if !errors.As(err, &formulaErr) {
+			t.Errorf("%q: got %v, want a FormulaError", tt.formula, err)
+			continue
+		}
+		if formulaErr.Column != tt.column || !strings.Contains(formulaErr.Message, tt.message) {
+			t.Errorf("%q: got column %d %q, want column %d %q", tt.formula, formulaErr.Column, formulaErr.Message, tt.column, tt.message)
+		}
+	}
+}
+
+func TestFormulaPrice(t *testing.T) {
+	variables := map[string]float64{"quantity": 100, "sides": 2, "colours": 4, "size.area_mm2": 62370, "material.gsm": 350}
+	tests := []struct {
+		formula string
+		want    Money
+	}{
+		{"40 + size.area_mm2 / 1000000 * 12 * quantity", 11484},
+		{"1 + 2 * 3", 700},
+		{"(1 + 2) * 3", 900},
+		{"10 - 4 - 3", 300},
+		{"24 / 4 / 2", 300},
+		{"-quantity + 150", 5000},
+		{"if(quantity >= 500, 0.1, 0.2) * quantity", 2000},
+		{"max(10, quantity * 0.05, 3) + min(quantity, 2)", 1200},
+		{"ceil(quantity / 30) * 2", 800},
+		{"floor(2.7) + round(2.5)", 500},
+		{"sides * colours", 800},
+		{"(quantity == 100) + (quantity != 100) + (sides < colours) + (material.gsm <= 300)", 200},
+		// variables the quotation has no value for are 0
+		{"option.area + 1", 100},
+		{"0.005", 1},
+	}
+	for _, tt := range tests {
+		f, err := parseFormula(tt.formula)
+		if err != nil {
+			t.Errorf("%q: %v", tt.formula, err)
+			continue
+		}
+		got, err := f.price(variables, MoneyRounding{Mode: RoundHalfUp})
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %d, %v, want %d", tt.formula, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormulaPriceErrors(t *testing.T) {
+	variables := map[string]float64{"quantity": 100}
+	tests := []struct {
+		formula string
+		message string
+	}{
+		{"1 / (quantity - 100)", "division by zero"},
+		{"if(quantity > 50, 1 / 0, 1)", "division by zero"},
+		{"quantity - 200", "came to a negative price -100"},
+		{"quantity * 100000000000000", "is out of range"},
+	}
+	for _, tt := range tests {
+		f, err := parseFormula(tt.formula)
+		if err != nil {
+			t.Errorf("%q: %v", tt.formula, err)
+			continue
+		}
+		_, err = f.price(variables, MoneyRounding{Mode: RoundHalfUp})
+		var formulaErr *FormulaError
+		if !errors.As(err, &formulaErr) || !strings.Contains(formulaErr.Message, tt.message) {
+			t.Errorf("%q: got %v, want %q", tt.formula, err, tt.message)
+		}
+	}
+	// the branch not taken is never evaluated
+	f, _ := parseFormula("if(quantity > 50, 1, 1 / 0)")
+	if _, err := f.price(variables, MoneyRounding{Mode: RoundHalfUp}); err != nil {
+		t.Error(err)
+	}
+}
+
+// withFoil adds a secondary add-on priced by formula alone, with one option
+// whose own formula fails at 100 pcs
+func withFoil(t *testing.T, catalog *Catalog) *Catalog {
+	t.Helper()
+	catalog.AddOns = append(catalog.AddOns, AddOnType{
+		Key: "foil", Label: "foil", Tier: AddOnTierSecondary, RowLabel: "foil", Spec: AddOnSpecOption, None: "none",
+		Formula: "10 + 0.05 * quantity",
+		Options: []CatalogOption{{Key: "none"}, {Key: "gold"}, {Key: "silver", Formula: "100 / (quantity - 100)"}},
+	})
+	if err := catalog.normalise(); err != nil {
+		t.Fatal(err)
+	}
+	return catalog
+}
+
+func TestCatalogFormulas(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Catalog)
+	}{
+		{"add-on", func(c *Catalog) { c.AddOns[0].Formula = "quantity * paper" }},
+		{"option", func(c *Catalog) { c.AddOns[0].Options[1].Formula = "system(1)" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := testCatalog(t)
+			tt.modify(catalog)
+			// a catalog with a broken formula never loads
+			err := catalog.normalise()
+			if err == nil || !strings.HasPrefix(err.Error(), "invalid catalog: add-on "+catalog.AddOns[0].Key) || !strings.Contains(err.Error(), "unknown") {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+	// formulas are never shown to customers
+	for _, group := range withFoil(t, testCatalog(t)).addOnGroups(AddOnTierSecondary) {
+		for _, option := range group.Options {
+			if option.Formula != "" || option.parsedFormula != nil {
+				t.Errorf("%s %s has its formula", group.Key, option.Key)
+			}
+		}
+	}
+}
+
+func TestFormulaAddOnQuotation(t *testing.T) {
+	catalog := withFoil(t, testCatalog(t))
+	tests := []struct {
+		option string
+		want   map[int]interface{}
+	}{
+		{"gold", map[int]interface{}{100: "115.00", 200: "200.00"}},
+		{"silver", map[int]interface{}{
+			100: []string{"foil silver is not available for 100 pcs"},
+			200: "181.00",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.option, func(t *testing.T) {
+			q := testQuotation(SelectedAddOn{"foil", tt.option})
+			if errs := q.validate(catalog); errs != nil {
+				t.Fatal(errs)
+			}
+			// a formula add-on has no table to fetch
+			if got, want := q.priceRanges(catalog), []string{printingRange}; !reflect.DeepEqual(got, want) {
+				t.Errorf("ranges %v, want %v", got, want)
+			}
+			pricings, err := q.calculateQuotation(context.Background(), testPrices(), catalog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := quotedTotals(pricings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComparePricesWithFormulaAddOn(t *testing.T) {
+	catalog := withFoil(t, testCatalog(t))
+	// testPrices has no range for foil, so fetching one would fail the comparison
+	comparisons, err := comparePrices(context.Background(), testPrices(), catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gold *PriceComparison
+	for i, comparison := range comparisons {
+		if comparison.AddOn == "foil" && comparison.Option == "gold" && comparison.SizeCategory == "A4" && comparison.Quantity == 100 {
+			gold = &comparisons[i]
+		}
+	}
+	if gold == nil {
+		t.Fatal("foil gold on A4 was not compared")
+	}
+	if gold.SheetPrice == nil || *gold.SheetPrice != 15*Ringgit {
+		t.Errorf("sheet price %v, want the formula's 15.00", gold.SheetPrice)
+	}
+}
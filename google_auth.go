@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Ways of authenticating to Google, selected with GOOGLE_AUTH_MODE
+const (
+	// Installed-app OAuth: client secret in credentials.json, user token in token.json
+	authModeOAuth = "oauth"
+	// Service account key from GOOGLE_SERVICE_ACCOUNT_FILE or GOOGLE_SERVICE_ACCOUNT_JSON
+	authModeServiceAccount = "service_account"
+	// Application default credentials: GOOGLE_APPLICATION_CREDENTIALS, gcloud, or the metadata server
+	authModeDefault = "default"
+)
+
+var sheetsScopes = []string{sheets.SpreadsheetsScope}
+
+func getEnvOrDefault(key string, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// connectToGoogleSheet returns the Sheets client, and in oauth mode the token
+// store the admin page connects and disconnects.
+func connectToGoogleSheet() (*sheets.Service, *oauthTokenStore, error) {
+	ctx := context.Background()
+	mode := getEnvOrDefault("GOOGLE_AUTH_MODE", authModeOAuth)
+	var client *http.Client
+	var store *oauthTokenStore
+	var err error
+	switch mode {
+	case authModeOAuth:
+		client, store, err = newOAuthClient(ctx)
+	case authModeServiceAccount:
+		client, err = newServiceAccountClient(ctx)
+	case authModeDefault:
+		client, err = newDefaultCredentialsClient(ctx)
+	default:
+		err = fmt.Errorf("unknown mode, expected %s, %s or %s", authModeOAuth, authModeServiceAccount, authModeDefault)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("GOOGLE_AUTH_MODE=%s: %w", mode, err)
+	}
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	return srv, store, err
+}
+
+// newOAuthClient signs in as the Google user an admin connected through
+// /admin/google. Until then every Sheets call fails with errGoogleNotConnected.
+func newOAuthClient(ctx context.Context) (*http.Client, *oauthTokenStore, error) {
+	credentialsFile := getEnvOrDefault("GOOGLE_OAUTH_CREDENTIALS_FILE", "credentials.json")
+	b, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read client secret file: %w", err)
+	}
+	config, err := google.ConfigFromJSON(b, sheetsScopes...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse client secret file %s: %w", credentialsFile, err)
+	}
+	config.RedirectURL = getEnvOrDefault("GOOGLE_OAUTH_REDIRECT_URL", fmt.Sprintf("https://%s:%s/oauth2/callback", os.Getenv("HOST"), os.Getenv("PORT")))
+	store := newOAuthTokenStore(config, getEnvOrDefault("GOOGLE_OAUTH_TOKEN_FILE", "token.json"))
+	return oauth2.NewClient(ctx, store), store, nil
+}
+
+func newServiceAccountClient(ctx context.Context) (*http.Client, error) {
+	var b []byte
+	var from string
+	if keyJSON := os.Getenv("GOOGLE_SERVICE_ACCOUNT_JSON"); keyJSON != "" {
+		b, from = []byte(keyJSON), "GOOGLE_SERVICE_ACCOUNT_JSON"
+	} else if keyFile := os.Getenv("GOOGLE_SERVICE_ACCOUNT_FILE"); keyFile != "" {
+		var err error
+		b, err = os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read service account key: %w", err)
+		}
+		from = keyFile
+	} else {
+		return nil, errors.New("set GOOGLE_SERVICE_ACCOUNT_FILE or GOOGLE_SERVICE_ACCOUNT_JSON to the service account key")
+	}
+
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+	}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, fmt.Errorf("service account key from %s is not valid JSON: %w", from, err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("key from %s has type %q, expected service_account", from, key.Type)
+	}
+	config, err := google.JWTConfigFromJSON(b, sheetsScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account key from %s: %w", from, err)
+	}
+	checkTokenSource(config.TokenSource(ctx), "service account "+key.ClientEmail)
+	return config.Client(ctx), nil
+}
+
+func newDefaultCredentialsClient(ctx context.Context) (*http.Client, error) {
+	creds, err := google.FindDefaultCredentials(ctx, sheetsScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("no application default credentials found: %w", err)
+	}
+	checkTokenSource(creds.TokenSource, "application default credentials")
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+}
+
+// checkTokenSource fetches a token up front so bad credentials show up in the
+// startup log rather than on the first quotation. Google being unreachable at
+// startup is not fatal, the price cache retries later.
+func checkTokenSource(ts oauth2.TokenSource, name string) {
+	if _, err := ts.Token(); err != nil {
+		log.Printf("Unable to get a Google token using %s: %v", name, err)
+		return
+	}
+	log.Printf("Authenticated to Google using %s", name)
+}
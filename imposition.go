@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Press is a press in the catalog, named the way the sizes' presses are, with
+// the parent sheet it prints on
+type Press struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	// MaxQuantity is the longest run the press is used for, 0 for no limit.
+	// A quotation quantity goes on the first press listed that takes it.
+	MaxQuantity int `json:"maxQuantity,omitempty"`
+	PressSheet
+	PressCosts
+}
+
+// PressSheet is a parent sheet and what is lost from it. The gripper edge runs
+// along the sheet's width.
+type PressSheet struct {
+	SheetWidthMM  float64 `json:"sheetWidthMm"`
+	SheetHeightMM float64 `json:"sheetHeightMm"`
+	GripperMM     float64 `json:"gripperMm,omitempty"`
+	// MarginMM is kept clear on the other three edges, GutterMM between ups
+	MarginMM float64 `json:"marginMm,omitempty"`
+	GutterMM float64 `json:"gutterMm,omitempty"`
+	// MakeReadySheets are run before the job is up to colour, SpoilagePercent
+	// of the run is added for sheets spoilt on the press and in finishing
+	MakeReadySheets int     `json:"makeReadySheets,omitempty"`
+	SpoilagePercent float64 `json:"spoilagePercent,omitempty"`
+}
+
+// Imposition is how a blank is laid out on the parent sheet and how many
+// sheets a quantity takes
+type Imposition struct {
+	Press         string  `json:"press,omitempty"`
+	SheetWidthMM  float64 `json:"sheetWidthMm"`
+	SheetHeightMM float64 `json:"sheetHeightMm"`
+	BlankWidthMM  float64 `json:"blankWidthMm"`
+	BlankHeightMM float64 `json:"blankHeightMm"`
+	// UpsAsIs and UpsRotated fit each way round, rotated blanks having their
+	// width down the sheet. The layout used is whichever fits more.
+	UpsAsIs    int  `json:"upsAsIs"`
+	UpsRotated int  `json:"upsRotated"`
+	Rotated    bool `json:"rotated"`
+	Across     int  `json:"across"`
+	Down       int  `json:"down"`
+	Ups        int  `json:"ups"`
+
+	Quantity        int `json:"quantity"`
+	RunSheets       int `json:"runSheets"`
+	MakeReadySheets int `json:"makeReadySheets"`
+	SpoilageSheets  int `json:"spoilageSheets"`
+	Sheets          int `json:"sheets"`
+}
+
+func (s PressSheet) check() error {
+	switch {
+	case s.SheetWidthMM <= 0 || s.SheetHeightMM <= 0:
+		return errors.New("needs a positive sheetWidthMm and sheetHeightMm")
+	case s.GripperMM < 0 || s.MarginMM < 0 || s.GutterMM < 0:
+		return errors.New("has a negative gripper, margin or gutter")
+	case s.MakeReadySheets < 0 || s.SpoilagePercent < 0 || s.SpoilagePercent >= 100:
+		return errors.New("needs makeReadySheets of 0 or more and spoilagePercent from 0 to under 100")
+	}
+	return nil
+}
+
+func (c *Catalog) normalisePresses() error {
+	seen := make(map[string]bool, len(c.Presses))
+	for i := range c.Presses {
+		press := &c.Presses[i]
+		if press.Key == "" {
+			return errors.New("invalid catalog: press without a key")
+		}
+		if seen[strings.ToLower(press.Key)] {
+			return fmt.Errorf("invalid catalog: press %s is listed more than once", press.Key)
+		}
+		seen[strings.ToLower(press.Key)] = true
+		if press.Label == "" {
+			press.Label = press.Key
+		}
+		if press.MaxQuantity < 0 {
+			return fmt.Errorf("invalid catalog: press %s has a negative maxQuantity", press.Key)
+		}
+		if err := press.check(); err != nil {
+			return fmt.Errorf("invalid catalog: press %s %v", press.Key, err)
+		}
+	}
+	if len(c.Presses) == 0 {
+		return nil
+	}
+	for _, size := range c.SizeCategories {
+		if size.SizeSpec == nil {
+			continue
+		}
+		for _, name := range size.Presses {
+			if _, ok := c.press(name); !ok {
+				return fmt.Errorf("invalid catalog: size %s has unknown press %q", size.Key, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) press(key string) (Press, bool) {
+	for _, press := range c.Presses {
+		if strings.EqualFold(press.Key, key) {
+			return press, true
+		}
+	}
+	return Press{}, false
+}
+
+// pressFor is the first press that prints the size and takes the quantity
+func (c *Catalog) pressFor(size string, quantity int) (Press, bool) {
+	spec := c.sizeSpec(size)
+	for _, press := range c.Presses {
+		if spec.printsOn(press.Key) && (press.MaxQuantity == 0 || quantity <= press.MaxQuantity) {
+			return press, true
+		}
+	}
+	return Press{}, false
+}
+
+// ups is how many blanks fit across and down the sheet laid out as they are
+func (s PressSheet) ups(width float64, height float64) (across int, down int) {
+	usableWidth := s.SheetWidthMM - 2*s.MarginMM
+	usableHeight := s.SheetHeightMM - s.GripperMM - s.MarginMM
+	fit := func(usable float64, blank float64) int {
+		if blank <= 0 || usable < blank {
+			return 0
+		}
+		// a gutter between each pair of ups, none after the last
+		return int(math.Floor((usable + s.GutterMM) / (blank + s.GutterMM)))
+	}
+	return fit(usableWidth, width), fit(usableHeight, height)
+}
+
+// impose lays the blank out on the sheet and counts the sheets for quantity
+func (s PressSheet) impose(width float64, height float64, quantity int) (Imposition, error) {
+	across, down := s.ups(width, height)
+	rotatedAcross, rotatedDown := s.ups(height, width)
+	imposition := Imposition{
+		SheetWidthMM: s.SheetWidthMM, SheetHeightMM: s.SheetHeightMM,
+		BlankWidthMM: width, BlankHeightMM: height,
+		UpsAsIs: across * down, UpsRotated: rotatedAcross * rotatedDown,
+		Across: across, Down: down,
+		Quantity: quantity,
+	}
+	if imposition.UpsRotated > imposition.UpsAsIs {
+		imposition.Rotated, imposition.Across, imposition.Down = true, rotatedAcross, rotatedDown
+	}
+	imposition.Ups = imposition.Across * imposition.Down
+	if imposition.Ups == 0 {
+		return imposition, fmt.Errorf("the %g x %g mm blank doesn't fit the %g x %g mm sheet", width, height, s.SheetWidthMM, s.SheetHeightMM)
+	}
+	imposition.RunSheets = (quantity + imposition.Ups - 1) / imposition.Ups
+	imposition.MakeReadySheets = s.MakeReadySheets
+	imposition.SpoilageSheets = int(math.Ceil(float64(imposition.RunSheets) * s.SpoilagePercent / 100))
+	imposition.Sheets = imposition.RunSheets + imposition.MakeReadySheets + imposition.SpoilageSheets
+	return imposition, nil
+}
+
+// blankSize is the flat blank of the quotation: the box's blank, or else the
+// size category's sheet size
+func (q *Quotation) blankSize(catalog *Catalog) (width float64, height float64) {
+	if q.Box != nil && q.Box.BlankWidthMM > 0 {
+		return q.Box.BlankWidthMM, q.Box.BlankHeightMM
+	}
+	spec := catalog.sizeSpec(q.SizeCategory)
+	return spec.WidthMM, spec.HeightMM
+}
+
+// impositions lays out each quantity on the press it would go on. Quantities
+// no press takes, or a size without dimensions, are left out.
+func (q *Quotation) impositions(catalog *Catalog) []Imposition {
+	width, height := q.blankSize(catalog)
+	if width == 0 {
+		return nil
+	}
+	var impositions []Imposition
+	for _, quantity := range q.Quantity {
+		press, ok := catalog.pressFor(q.SizeCategory, quantity)
+		if !ok {
+			continue
+		}
+		imposition, err := press.impose(width, height, quantity)
+		if err != nil {
+			continue
+		}
+		imposition.Press = press.Key
+		impositions = append(impositions, imposition)
+	}
+	return impositions
+}
+
+// ImpositionRequest asks how a blank lays out. The blank is given directly,
+// as a box or as a size category; the sheet is a catalog press, a sheet of
+// its own, or else the press each quantity would go on.
+type ImpositionRequest struct {
+	BlankWidthMM  float64        `json:"blankWidthMm"`
+	BlankHeightMM float64        `json:"blankHeightMm"`
+	Box           *BoxDimensions `json:"box"`
+	SizeCategory  string         `json:"sizeCategory"`
+	Press         string         `json:"press"`
+	Sheet         *PressSheet    `json:"sheet"`
+	Quantity      []int          `json:"quantity"`
+}
+
+type ImpositionResponse struct {
+	BlankWidthMM  float64      `json:"blankWidthMm"`
+	BlankHeightMM float64      `json:"blankHeightMm"`
+	Impositions   []Imposition `json:"impositions"`
+}
+
+func (r *ImpositionRequest) impose(catalog *Catalog) (*ImpositionResponse, ValidationErrors) {
+	var errs ValidationErrors
+	if len(r.Quantity) == 0 {
+		errs.add("quantity", "at least one quantity is required")
+	}
+	for i, quantity := range r.Quantity {
+		if quantity <= 0 {
+			errs.add(fmt.Sprintf("quantity[%d]", i), "must be a positive number of pieces")
+		}
+	}
+	q := &Quotation{SizeCategory: r.SizeCategory, Box: r.Box, Quantity: r.Quantity}
+	width, height := r.BlankWidthMM, r.BlankHeightMM
+	switch {
+	case width > 0 || height > 0:
+		if width <= 0 || height <= 0 {
+			errs.add("blankWidthMm", "needs both blankWidthMm and blankHeightMm")
+		}
+	case q.Box != nil:
+		q.applyBox(&errs, catalog)
+		width, height = q.blankSize(catalog)
+	default:
+		q.normaliseSize(catalog)
+		errs.checkOneOf("sizeCategory", q.SizeCategory, enabledKeys(catalog.SizeCategories))
+		if width, height = q.blankSize(catalog); width == 0 && len(errs) == 0 {
+			errs.add("sizeCategory", "%s has no widthMm and heightMm", q.SizeCategory)
+		}
+	}
+	var sheet *PressSheet
+	pressName := r.Press
+	if r.Sheet != nil {
+		if err := r.Sheet.check(); err != nil {
+			errs.add("sheet", "%v", err)
+		}
+		sheet = r.Sheet
+	} else if r.Press != "" {
+		press, ok := catalog.press(r.Press)
+		if !ok {
+			keys := make([]string, 0, len(catalog.Presses))
+			for _, press := range catalog.Presses {
+				keys = append(keys, press.Key)
+			}
+			errs.checkOneOf("press", r.Press, keys)
+		}
+		sheet, pressName = &press.PressSheet, press.Key
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	response := &ImpositionResponse{BlankWidthMM: width, BlankHeightMM: height, Impositions: []Imposition{}}
+	for i, quantity := range r.Quantity {
+		quantitySheet, quantityPress := sheet, pressName
+		if quantitySheet == nil {
+			press, ok := catalog.pressFor(q.SizeCategory, quantity)
+			if !ok {
+				errs.add(fmt.Sprintf("quantity[%d]", i), "no press takes %d pcs of this size", quantity)
+				continue
+			}
+			quantitySheet, quantityPress = &press.PressSheet, press.Key
+		}
+		imposition, err := quantitySheet.impose(width, height, quantity)
+		if err != nil {
+			errs.add(fmt.Sprintf("quantity[%d]", i), "%v", err)
+			continue
+		}
+		imposition.Press = quantityPress
+		response.Impositions = append(response.Impositions, imposition)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return response, nil
+}
+
+func registerImpositionRoutes(api fiber.Router, store *CatalogStore) {
+	api.Post("/imposition", func(c *fiber.Ctx) error {
+		request := new(ImpositionRequest)
+		if err := c.BodyParser(request); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		response, errs := request.impose(store.Current())
+		if errs != nil {
+			return validationErrorResponse(c, errs)
+		}
+		return c.JSON(response)
+	})
+}
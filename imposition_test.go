@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImpose(t *testing.T) {
+	digital := PressSheet{SheetWidthMM: 330, SheetHeightMM: 483, GripperMM: 5, MarginMM: 5, GutterMM: 3, MakeReadySheets: 5, SpoilagePercent: 2}
+	litho := PressSheet{SheetWidthMM: 635, SheetHeightMM: 889, GripperMM: 12, MarginMM: 5, GutterMM: 3, MakeReadySheets: 100, SpoilagePercent: 3}
+	tests := []struct {
+		name          string
+		sheet         PressSheet
+		width, height float64
+		quantity      int
+		want          Imposition
+	}{
+		{
+			name: "rotated fits more", sheet: digital, width: 210, height: 297, quantity: 100,
+			want: Imposition{UpsAsIs: 1, UpsRotated: 2, Rotated: true, Across: 1, Down: 2, Ups: 2, RunSheets: 50, MakeReadySheets: 5, SpoilageSheets: 1, Sheets: 56},
+		},
+		{
+			name: "as it is", sheet: digital, width: 148, height: 210, quantity: 500,
+			want: Imposition{UpsAsIs: 4, UpsRotated: 3, Across: 2, Down: 2, Ups: 4, RunSheets: 125, MakeReadySheets: 5, SpoilageSheets: 3, Sheets: 133},
+		},
+		{
+			name: "part sheet rounds up", sheet: litho, width: 210, height: 297, quantity: 1001,
+			want: Imposition{UpsAsIs: 4, UpsRotated: 8, Rotated: true, Across: 2, Down: 4, Ups: 8, RunSheets: 126, MakeReadySheets: 100, SpoilageSheets: 4, Sheets: 230},
+		},
+		{
+			name: "no gutter after the last up", sheet: PressSheet{SheetWidthMM: 203, SheetHeightMM: 100, GutterMM: 3}, width: 100, height: 100, quantity: 10,
+			want: Imposition{UpsAsIs: 2, UpsRotated: 2, Across: 2, Down: 1, Ups: 2, RunSheets: 5, Sheets: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sheet.impose(tt.width, tt.height, tt.quantity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.SheetWidthMM, tt.want.SheetHeightMM = tt.sheet.SheetWidthMM, tt.sheet.SheetHeightMM
+			tt.want.BlankWidthMM, tt.want.BlankHeightMM, tt.want.Quantity = tt.width, tt.height, tt.quantity
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImposeTooLarge(t *testing.T) {
+	sheet := PressSheet{SheetWidthMM: 330, SheetHeightMM: 483, GripperMM: 5, MarginMM: 5}
+	if _, err := sheet.impose(500, 300, 100); err == nil {
+		t.Error("a blank larger than the sheet was imposed")
+	}
+}
+
+func TestPressSheetCheck(t *testing.T) {
+	for name, sheet := range map[string]PressSheet{
+		"no size":          {SheetWidthMM: 330},
+		"negative margin":  {SheetWidthMM: 330, SheetHeightMM: 483, MarginMM: -1},
+		"all spoilt":       {SheetWidthMM: 330, SheetHeightMM: 483, SpoilagePercent: 100},
+		"negative sheets":  {SheetWidthMM: 330, SheetHeightMM: 483, MakeReadySheets: -5},
+		"negative gripper": {SheetWidthMM: 330, SheetHeightMM: 483, GripperMM: -5},
+	} {
+		if err := sheet.check(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestQuotationImpositions(t *testing.T) {
+	q := testQuotation()
+	q.Quantity = []int{100, 1000}
+	var got []string
+	for _, imposition := range q.impositions(testCatalog(t)) {
+		got = append(got, imposition.Press)
+	}
+	// runs up to 500 go on the digital press, longer ones on litho
+	if want := []string{"digital offset", "litho offset"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("presses %v, want %v", got, want)
+	}
+}
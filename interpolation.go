@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quantity pricing modes. exact only quotes the quantities the sheets have a
+// row for, interpolate estimates any other quantity from those rows.
+const (
+	QuantityPricingExact       = "exact"
+	QuantityPricingInterpolate = "interpolate"
+)
+
+// Models for estimating a price between and beyond the sheet breakpoints
+const (
+	// InterpolationLinear draws a straight line between the two nearest
+	// breakpoints, and extends the last (or first) segment past the ends
+	InterpolationLinear = "linear"
+	// InterpolationUnitPrice interpolates the price per piece between the two
+	// nearest breakpoints and keeps the last (or first) unit price past the ends
+	InterpolationUnitPrice = "unit_price"
+	// InterpolationFixedVariable fits price = fixed + variable * quantity over
+	// every breakpoint, i.e. a set-up cost plus a cost per piece
+	InterpolationFixedVariable = "fixed_variable"
+)
+
+type QuantityPricing struct {
+	Mode        string
+	Model       string
+	MaxQuantity int
+}
+
+// quantityPricing is set from the environment at start up
+var quantityPricing = QuantityPricing{Mode: QuantityPricingExact, Model: InterpolationLinear, MaxQuantity: 10000}
+
+func (p QuantityPricing) interpolates() bool {
+	return p.Mode == QuantityPricingInterpolate
+}
+
+// largestQuantity is the most pieces a quotation is priced for: MAX_QUANTITY
+// when interpolating, else the largest catalog quantity
+func (p QuantityPricing) largestQuantity(catalog *Catalog) int {
+	if p.interpolates() {
+		return p.MaxQuantity
+	}
+	quantities := catalog.quantities()
+	if len(quantities) == 0 {
+		return p.MaxQuantity
+	}
+	return quantities[len(quantities)-1]
+}
+
+// quantityPricingFromEnv reads PRICING_MODE, INTERPOLATION_MODEL and MAX_QUANTITY
+func quantityPricingFromEnv() (QuantityPricing, error) {
+	p := QuantityPricing{
+		Mode:        strings.ToLower(getEnvOrDefault("PRICING_MODE", QuantityPricingExact)),
+		Model:       strings.ToLower(getEnvOrDefault("INTERPOLATION_MODEL", InterpolationLinear)),
+		MaxQuantity: 10000,
+	}
+	switch p.Mode {
+	case QuantityPricingExact, QuantityPricingInterpolate:
+	default:
+		return p, fmt.Errorf("PRICING_MODE must be %s or %s, got %q", QuantityPricingExact, QuantityPricingInterpolate, p.Mode)
+	}
+	switch p.Model {
+	case InterpolationLinear, InterpolationUnitPrice, InterpolationFixedVariable:
+	default:
+		return p, fmt.Errorf("INTERPOLATION_MODEL must be %s, %s or %s, got %q", InterpolationLinear, InterpolationUnitPrice, InterpolationFixedVariable, p.Model)
+	}
+	if value := os.Getenv("MAX_QUANTITY"); value != "" {
+		maxQuantity, err := strconv.Atoi(value)
+		if err != nil || maxQuantity <= 0 {
+			return p, fmt.Errorf("MAX_QUANTITY must be a positive number, got %q", value)
+		}
+		p.MaxQuantity = maxQuantity
+	}
+	return p, nil
+}
+
+// quantityRow is a sheet row that prices one quantity
+type quantityRow struct {
+	quantity int
+	index    int
+	row      []interface{}
+}
+
+// quantityTable holds the rows of one range that price the same thing at
+// different quantities, e.g. matt lam 1side on A4
+type quantityTable struct {
+	range_ string
+	rows   []quantityRow
+	// byQuantity is the first row of each quantity
+	byQuantity map[int]int
+}
+
+func (t quantityTable) add(range_ string, r quantityRow) quantityTable {
+	if t.byQuantity == nil {
+		t.range_, t.byQuantity = range_, make(map[int]int)
+	}
+	if _, ok := t.byQuantity[r.quantity]; !ok {
+		t.byQuantity[r.quantity] = len(t.rows)
+	}
+	t.rows = append(t.rows, r)
+	return t
+}
+
+// quotedPrice is a price cell as it goes on a line item
+type quotedPrice struct {
+	Price Money
+	// NotAvailable is a blank or "not available" cell
+	NotAvailable  bool
+	Source        *SourceRow
+	Estimated     bool
+	EstimatedFrom []int
+}
+
+// priceCell reads the price in column of a row. ok is false for blank and
+// "not available" cells; anything else that isn't an amount is malformed.
+func priceCell(row []interface{}, column int, range_ string, rowIndex int) (Money, bool, error) {
+	value, err := cellString(row, column, range_, rowIndex)
+	if err != nil {
+		return 0, false, err
+	}
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "not available") {
+		return 0, false, nil
+	}
+	price, err := ParseMoney(value)
+	if err != nil {
+		return 0, false, newPricingError(ErrMalformedRow, range_, rowIndex+1, "column %d: %v", column+1, err)
+	}
+	return price, true, nil
+}
+
+// priceAt returns the price in column for quantity. A row for that exact
+// quantity is used as it is; otherwise, when quantity pricing interpolates,
+// the price is estimated from the rows that have a price. A quantity with
+// nothing to quote is not available, so the line item is never left out.
+func (t quantityTable) priceAt(quantity int, column int) (quotedPrice, error) {
+	if i, ok := t.byQuantity[quantity]; ok {
+		r := t.rows[i]
+		source := &SourceRow{Range: t.range_, Row: r.index + 1}
+		// the sheet drops trailing blank cells, so a row cut short before
+		// column is a blank price, not a missing row to estimate over
+		if column >= len(r.row) {
+			return quotedPrice{NotAvailable: true, Source: source}, nil
+		}
+		price, available, err := priceCell(r.row, column, t.range_, r.index)
+		if err != nil {
+			return quotedPrice{}, err
+		}
+		return quotedPrice{Price: price, NotAvailable: !available, Source: source}, nil
+	}
+	if !quantityPricing.interpolates() {
+		return quotedPrice{NotAvailable: true}, nil
+	}
+	var points []pricePoint
+	seen := make(map[int]bool, len(t.rows))
+	for _, r := range t.rows {
+		if column >= len(r.row) || seen[r.quantity] {
+			continue
+		}
+		price, available, err := priceCell(r.row, column, t.range_, r.index)
+		if err != nil {
+			return quotedPrice{}, err
+		}
+		// blank and "not available" cells say nothing about the price curve
+		if !available {
+			continue
+		}
+		seen[r.quantity] = true
+		points = append(points, pricePoint{quantity: r.quantity, price: price.Ringgit()})
+	}
+	if len(points) == 0 {
+		return quotedPrice{NotAvailable: true}, nil
+	}
+	price, from := estimatePrice(points, quantity, quantityPricing.Model)
+	return quotedPrice{Price: moneyRounding.fromRinggit(price), Estimated: true, EstimatedFrom: from}, nil
+}
+
+type pricePoint struct {
+	quantity int
+	price    float64
+}
+
+// estimatePrice returns the price of quantity under model, and the breakpoint
+// quantities the estimate was worked out from
+func estimatePrice(points []pricePoint, quantity int, model string) (float64, []int) {
+	sort.Slice(points, func(i, j int) bool { return points[i].quantity < points[j].quantity })
+	var price float64
+	var used []pricePoint
+	switch model {
+	case InterpolationFixedVariable:
+		price, used = fixedVariablePrice(points, quantity), points
+	case InterpolationUnitPrice:
+		used = nearestPoints(points, quantity)
+		if len(used) == 2 && quantity < used[0].quantity {
+			used = used[:1]
+		} else if len(used) == 2 && quantity > used[1].quantity {
+			used = used[1:]
+		}
+		unitPrice := used[0].price / float64(used[0].quantity)
+		if len(used) == 2 {
+			unitPrice = lerp(used[0].quantity, unitPrice, used[1].quantity, used[1].price/float64(used[1].quantity), quantity)
+		}
+		price = unitPrice * float64(quantity)
+	default:
+		used = nearestPoints(points, quantity)
+		if len(used) == 1 {
+			price = used[0].price * float64(quantity) / float64(used[0].quantity)
+		} else {
+			price = lerp(used[0].quantity, used[0].price, used[1].quantity, used[1].price, quantity)
+		}
+	}
+	from := make([]int, len(used))
+	for i, point := range used {
+		from[i] = point.quantity
+	}
+	return math.Max(price, 0), from
+}
+
+// nearestPoints returns the two breakpoints either side of quantity, or the
+// first or last two when quantity is outside them
+func nearestPoints(points []pricePoint, quantity int) []pricePoint {
+	if len(points) < 2 {
+		return points
+	}
+	i := sort.Search(len(points), func(i int) bool { return points[i].quantity >= quantity })
+	switch {
+	case i == 0:
+		return points[:2]
+	case i == len(points):
+		return points[len(points)-2:]
+	default:
+		return points[i-1 : i+1]
+	}
+}
+
+func lerp(x0 int, y0 float64, x1 int, y1 float64, x int) float64 {
+	return y0 + (y1-y0)*float64(x-x0)/float64(x1-x0)
+}
+
+// fixedVariablePrice fits price = fixed + variable * quantity by least squares
+func fixedVariablePrice(points []pricePoint, quantity int) float64 {
+	var meanQuantity, meanPrice float64
+	for _, point := range points {
+		meanQuantity += float64(point.quantity)
+		meanPrice += point.price
+	}
+	meanQuantity /= float64(len(points))
+	meanPrice /= float64(len(points))
+	var covariance, variance float64
+	for _, point := range points {
+		covariance += (float64(point.quantity) - meanQuantity) * (point.price - meanPrice)
+		variance += (float64(point.quantity) - meanQuantity) * (float64(point.quantity) - meanQuantity)
+	}
+	if variance == 0 {
+		// a single breakpoint, treat it as all variable cost
+		return meanPrice * float64(quantity) / meanQuantity
+	}
+	variable := covariance / variance
+	fixed := meanPrice - variable*meanQuantity
+	return fixed + variable*float64(quantity)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// setQuantityPricing changes how quantities are priced for the rest of a test
+func setQuantityPricing(t *testing.T, p QuantityPricing) {
+	t.Helper()
+	saved := quantityPricing
+	quantityPricing = p
+	t.Cleanup(func() { quantityPricing = saved })
+}
+
+func TestEstimatePrice(t *testing.T) {
+	breakpoints := []pricePoint{{100, 100}, {200, 180}, {500, 390}}
+	tests := []struct {
+		name     string
+		points   []pricePoint
+		quantity int
+		model    string
+		want     float64
+		wantFrom []int
+	}{
+		{"linear between", breakpoints, 300, InterpolationLinear, 250, []int{200, 500}},
+		{"linear below", breakpoints, 50, InterpolationLinear, 60, []int{100, 200}},
+		{"linear above", breakpoints, 1000, InterpolationLinear, 740, []int{200, 500}},
+		{"linear one breakpoint", []pricePoint{{100, 100}}, 250, InterpolationLinear, 250, []int{100}},
+		{"never below zero", []pricePoint{{100, 100}, {200, 50}}, 400, InterpolationLinear, 0, []int{100, 200}},
+		{"unit price between", breakpoints, 300, InterpolationUnitPrice, 258, []int{200, 500}},
+		{"unit price below", breakpoints, 50, InterpolationUnitPrice, 50, []int{100}},
+		{"unit price above", breakpoints, 1000, InterpolationUnitPrice, 780, []int{500}},
+		{"fixed and variable", []pricePoint{{100, 60}, {200, 100}, {500, 220}}, 1000, InterpolationFixedVariable, 420, []int{100, 200, 500}},
+		{"fixed and variable one breakpoint", []pricePoint{{100, 60}}, 300, InterpolationFixedVariable, 180, []int{100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := append([]pricePoint{}, tt.points...)
+			got, from := estimatePrice(points, tt.quantity, tt.model)
+			if math.Abs(got-tt.want) > 1e-9 || !reflect.DeepEqual(from, tt.wantFrom) {
+				t.Errorf("got %v from %v, want %v from %v", got, from, tt.want, tt.wantFrom)
+			}
+		})
+	}
+}
+
+func TestPriceAt(t *testing.T) {
+	var table quantityTable
+	for i, row := range [][]interface{}{
+		{"x", "A4", "100", "100.00"},
+		{"x", "A4", "200", ""},
+		{"x", "A4", "500", "300.00"},
+		{"x", "A4", "1000"},
+	} {
+		table = table.add("addons", quantityRow{quantity: [...]int{100, 200, 500, 1000}[i], index: i, row: row})
+	}
+	tests := []struct {
+		name     string
+		mode     string
+		quantity int
+		want     quotedPrice
+	}{
+		{"exact row", QuantityPricingExact, 100, quotedPrice{Price: 10000, Source: &SourceRow{"addons", 1}}},
+		{"blank cell", QuantityPricingExact, 200, quotedPrice{NotAvailable: true, Source: &SourceRow{"addons", 2}}},
+		{"row cut short", QuantityPricingExact, 1000, quotedPrice{NotAvailable: true, Source: &SourceRow{"addons", 4}}},
+		{"no row", QuantityPricingExact, 300, quotedPrice{NotAvailable: true}},
+		{"interpolated row used as it is", QuantityPricingInterpolate, 200, quotedPrice{NotAvailable: true, Source: &SourceRow{"addons", 2}}},
+		// the blank 200 and cut short 1000 rows are no breakpoints
+		{"interpolated", QuantityPricingInterpolate, 300, quotedPrice{Price: 20000, Estimated: true, EstimatedFrom: []int{100, 500}}},
+		{"extrapolated", QuantityPricingInterpolate, 2000, quotedPrice{Price: 105000, Estimated: true, EstimatedFrom: []int{100, 500}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setQuantityPricing(t, QuantityPricing{Mode: tt.mode, Model: InterpolationLinear, MaxQuantity: 10000})
+			got, err := table.priceAt(tt.quantity, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceAtWithoutBreakpoints(t *testing.T) {
+	setQuantityPricing(t, QuantityPricing{Mode: QuantityPricingInterpolate, Model: InterpolationLinear, MaxQuantity: 10000})
+	table := quantityTable{}.add("addons", quantityRow{quantity: 100, index: 0, row: []interface{}{"x", "A4", "100", "not available"}})
+	got, err := table.priceAt(300, 3)
+	if err != nil || !got.NotAvailable {
+		t.Errorf("got %+v, %v, want not available", got, err)
+	}
+}
+
+func TestInterpolatedQuotation(t *testing.T) {
+	setQuantityPricing(t, QuantityPricing{Mode: QuantityPricingInterpolate, Model: InterpolationLinear, MaxQuantity: 5000})
+	catalog := testCatalog(t)
+	q := testQuotation()
+	q.Quantity = []int{150, 250}
+	if errs := q.validate(catalog); errs != nil {
+		t.Fatal(errs)
+	}
+	pricings, err := q.priceQuotation(mustFetch(t, testPrices(), q.priceRanges(catalog)), catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := quotedTotals(pricings), map[int]interface{}{150: "140.00", 250: "220.00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !pricings[0].Estimated || !pricings[0].LineItems[0].Estimated {
+		t.Error("interpolated price is not marked estimated")
+	}
+	if got := q.getHeader(catalog).MaxQuantity; got != 5000 {
+		t.Errorf("header max quantity %d, want 5000", got)
+	}
+
+	q.Quantity = []int{0, 6000}
+	if got, want := fieldErrors(q.validate(catalog)), []string{"quantity[0]", "quantity[1]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLargestQuantity(t *testing.T) {
+	catalog := testCatalog(t)
+	if got := (QuantityPricing{Mode: QuantityPricingExact, MaxQuantity: 10000}).largestQuantity(catalog); got != 2000 {
+		t.Errorf("exact: got %d, want the largest catalog quantity 2000", got)
+	}
+	if got := (QuantityPricing{Mode: QuantityPricingInterpolate, MaxQuantity: 10000}).largestQuantity(catalog); got != 10000 {
+		t.Errorf("interpolate: got %d, want 10000", got)
+	}
+}
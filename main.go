@@ -2,359 +2,297 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"net/http"
 	"os"
-	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/template/html/v2"
 	"github.com/joho/godotenv"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
-	"google.golang.org/api/sheets/v4"
 )
 
-var (
-	// Printing
-	// If readied size is true, there will be discount
-	materials     = []string{"art card 350gsm", "art card 300gsm", "art card 260gsm", "boxboard 350gsm", "boxboard 300gsm", "boxboard 260gsm", "carton box e flute wrapped by art card 250gsm : ac250/bt115(f)/bt150", "carton box e flute wrapped by boxboard 250gsm : bb250/bt115(f)/bt150", "carton box e flute wrapped by brown testliner 180gsm : bt180/bt125(f)/bt150", "carton box e flute wrapped by white testliner 175gsm : wt175/bt125(f)/bt150", "white coated kraft 350gsm", "white coated kraft 300gsm"}
-	categorySize  = []string{"A2", "A3+", "A3", "A4+", "A4", "A5", "A5+"}
-	quantityRange = []int{100, 200, 300, 400, 500, 1000, 1500, 2000}
-	// Primary Addons
-	surfaceProtectionPrinting = []string{"no finishing (may cause colour rubbing issue)", "water base normal 1side", "water base food grade 1side", "uv varnish 1side", "spot uv 1side", "gloss lam 1side", "matt lam 1side"}
-	// Secondary Addons
-	windowHoleWithoutTransparentPVCSheet = []string{"none", "within 3mm to 50mm", "within 90mm x 54mm", "within 148mm x 105mm", "within 210mm x 148mm", "within 222mm x 190mm", "within 297mm x 210mm", "within 300mm x 297mm", "within 420mm x 297mm"}
-	windowHoleWithTransparentPVCSheet    = []string{"none", "within 45mm x 45mm", "within 90mm x 54mm", "within 90mm x 90mm", "within 148mm x 210mm", "within 297mm x 210mm"}
-	hotstamping                          = []string{"none", "within 16 square inch", "within 24 square inch", "within 32 square inch"}
-	emboss_deboss                        = []string{"none", "within 16 square inch", "within 24 square inch", "within 32 square inch"}
-	stringFinishing                      = []string{"none", "12inch", "14inch", "16inch", "18inch", "20inch", "22inch", "24inch", "26inch", "28inch", "30inch"}
-	// Third Addons
-	// Double sides printing
-	finishingAnotherSide = []string{"no finishing (may cause colour rubbing issue)", "uv varnish 1side", "spot uv 1side", "water base normal 1side", "water base food grade 1side", "matt lam 1side", "gloss lam 1side"}
-	noOfColours          = []string{"0colour", "1colour", "4colours"}
-	isReadiedSize        = []string{"custom", "readied"}
-)
-
-type ThirdAddOns struct {
-	IsDoubleSide         bool   `json:"isDoubleSide"`
-	FinishingAnotherSide string `json:"finishingAnotherSide"`
+type Quotation struct {
+	SizeCategory string          `json:"sizeCategory"`
+	Quantity     []int           `json:"quantity"`
+	Material     string          `json:"material"`
+	NoOfColours  string          `json:"noOfColours"`
+	ReadiedSize  bool            `json:"readiedSize"`
+	IsDoubleSide bool            `json:"isDoubleSide"`
+	AddOns       []SelectedAddOn `json:"addOns"`
+	// Add-on groups sent by older clients, moved into AddOns by normaliseAddOns
+	PrimaryAddOns   *PrimaryAddOns   `json:"primaryAddOns,omitempty"`
+	SecondaryAddOns *SecondaryAddOns `json:"secondaryAddOns,omitempty"`
+	ThirdAddOns     *ThirdAddOns     `json:"thirdAddOns,omitempty"`
+	Customer        string           `json:"customer,omitempty"`
+	// CustomerTier is one of the catalog customerTiers, for tier discounts
+	CustomerTier string `json:"customerTier,omitempty"`
+	// Box quotes by box dimensions, picking the size category from its blank
+	Box *BoxDimensions `json:"box,omitempty"`
 }
 
-type SecondaryAddOns struct {
-	SpotUV1Side                          string `json:"spotUV1Side"`
-	WindowHoleWithoutTransparentPVCSheet string `json:"windowHoleWithoutTransparentPVCSheet"`
-	WindowHoleWithTransparentPVCSheet    string `json:"windowHoleWithTransparentPVCSheet"`
-	Hotstamping                          string `json:"hotstamping"`
-	EmbossDeboss                         string `json:"embossDeboss"`
-	String                               string `json:"string"`
+// SourceRow points at the sheet row a price was read from
+type SourceRow struct {
+	Range string `json:"range"`
+	Row   int    `json:"row"`
 }
 
-type PrimaryAddOns struct {
-	SurfaceProtectionPrinting string `json:"surfaceProtectionPrinting"`
+// Line item kinds, in the order they are added to a Pricing
+const (
+	LineItemPrinting             = "printing"
+	LineItemPrimaryAddOn         = "primaryAddOn"
+	LineItemSecondaryAddOn       = "secondaryAddOn"
+	LineItemAnotherSidePrinting  = "anotherSidePrinting"
+	LineItemAnotherSideFinishing = "anotherSideFinishing"
+	LineItemDiscount             = "discount"
+	LineItemRounding             = "rounding"
+)
+
+type LineItem struct {
+	Kind   string     `json:"kind"`
+	Label  string     `json:"label"`
+	Option string     `json:"option,omitempty"`
+	Price  Money      `json:"price"`
+	Source *SourceRow `json:"source,omitempty"`
+	// Discount is the id of the discount rule a discount line item came from
+	Discount string `json:"discount,omitempty"`
+	Availability
+	// Estimated prices were interpolated from the sheet rows for EstimatedFrom
+	Estimated     bool  `json:"estimated,omitempty"`
+	EstimatedFrom []int `json:"estimatedFrom,omitempty"`
 }
 
-type Quotation struct {
-	SizeCategory    string          `json:"sizeCategory"`
-	Quantity        []int           `json:"quantity"`
-	Material        string          `json:"material"`
-	NoOfColours     string          `json:"noOfColours"`
-	ReadiedSize     bool            `json:"readiedSize"`
-	PrimaryAddOns   PrimaryAddOns   `json:"primaryAddOns"`
-	SecondaryAddOns SecondaryAddOns `json:"secondaryAddOns"`
-	ThirdAddOns     ThirdAddOns     `json:"thirdAddOns"`
+func newLineItem(kind string, label string, option string, quoted quotedPrice) LineItem {
+	item := LineItem{Kind: kind, Label: label, Option: option, Price: quoted.Price, Source: quoted.Source, Availability: available(), Estimated: quoted.Estimated, EstimatedFrom: quoted.EstimatedFrom}
+	if quoted.NotAvailable {
+		item.Availability = unavailable()
+	}
+	return item
 }
 
 type Pricing struct {
-	Quantity     int      `json:"quantity"`
-	Price        []string `json:"price"`
-	PriceLabel   string   `json:"priceLabel"`
-	ReadiedSize  bool     `json:"readiedSize"`
-	NoOfColours  int      `json:"noOfColours"`
-	IsDoubleSide bool     `json:"isDoubleSide"`
-	Header       string   `json:"header"`
-	SizeCategory string   `json:"sizeCategory"`
+	Quantity     int        `json:"quantity"`
+	LineItems    []LineItem `json:"lineItems"`
+	PriceLabel   string     `json:"priceLabel"`
+	ReadiedSize  bool       `json:"readiedSize"`
+	NoOfColours  string     `json:"noOfColours"`
+	IsDoubleSide bool       `json:"isDoubleSide"`
+	SizeCategory string     `json:"sizeCategory"`
+	// Total is only quoted when every line item is available
+	Total     Money `json:"total"`
+	Estimated bool  `json:"estimated"`
+	Availability
 }
 
-// Instead of using []string, use hashmap to store the value
-// func (q *Quotation) getPrintingCost(srv *sheets.Service, spreadsheetId string, range_ string) (string, []string, error) {
-func (q *Quotation) getPrintingCost(srv *sheets.Service, spreadsheetId string, range_ string) (string, map[string]string, error) {
-	// Go to google sheet and get the printing cost
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, range_).Do()
-	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+func (q *Quotation) newPricing(catalog *Catalog, quantity int) *Pricing {
+	return &Pricing{
+		Quantity:     quantity,
+		LineItems:    []LineItem{},
+		PriceLabel:   q.getPrintingAddonsLabel(catalog),
+		ReadiedSize:  q.ReadiedSize,
+		NoOfColours:  q.NoOfColours,
+		IsDoubleSide: q.IsDoubleSide,
+		SizeCategory: q.SizeCategory,
+		Availability: available(),
 	}
-	var search_str_noOfColours string = q.NoOfColours
-	var search_str_material string = q.Material
-	var search_str_sizeCategory string = q.SizeCategory
+}
 
-	var quotationStringTemplate string = "<Header>\n"
-	var priceMap = make(map[string]string)
-	for _, quantity := range q.Quantity {
-		for _, row := range resp.Values {
-			if len(row) == 6 {
-				quantity_string := strconv.Itoa(quantity)
-				if err != nil {
-					fmt.Println("Error converting int to string \n", err)
-				}
-				if row[1] == search_str_noOfColours && row[2] == search_str_material && row[4] == quantity_string && row[3] == search_str_sizeCategory {
-					temp := fmt.Sprintf("📌 *%s pcs*: RM%s Printing <Primary%s><Secondary%s><Third%s><ReadiedSizeDiscount%s><Total%s>\n", row[4], row[5], strconv.Itoa(quantity), strconv.Itoa(quantity), strconv.Itoa(quantity), strconv.Itoa(quantity), strconv.Itoa(quantity))
-					quotationStringTemplate += temp
-					priceMap[quantity_string] = row[5].(string)
-				}
-			}
+func (p *Pricing) addLineItem(item LineItem) {
+	p.Estimated = p.Estimated || item.Estimated
+	if !item.Available {
+		if len(item.Reasons) == 0 {
+			item.Reasons = []string{item.unavailableReason(p.Quantity)}
 		}
+		p.Available = false
+		p.Reasons = append(p.Reasons, item.Reasons...)
 	}
-
-	return quotationStringTemplate, priceMap, nil
+	p.LineItems = append(p.LineItems, item)
+	p.Total += item.Price
 }
 
-func (q *Quotation) addTotalPriceInString(priceMap map[string]string, quantity string, value string) map[string]string {
-	// convert priceMap value to float
-	if value == "not available" {
-		priceMap[quantity] = "not available"
-		return priceMap
-	}
-	if priceMap[quantity] == "not available" {
-		return priceMap
-	}
-	price, err := strconv.ParseFloat(priceMap[quantity], 64)
-	if err != nil {
-		fmt.Println("Error converting string to float \n", err)
-	}
-	// convert value to float
-	value_float, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		fmt.Println("Error converting string to float \n", err)
+// roundTotal rounds the total as moneyRounding says, adding the difference as
+// a line item so the breakdown still adds up
+func (p *Pricing) roundTotal(rounding MoneyRounding) {
+	if adjustment := rounding.roundTotal(p.Total) - p.Total; adjustment != 0 && p.Available {
+		p.addLineItem(LineItem{Kind: LineItemRounding, Label: "rounding", Price: adjustment, Availability: available()})
 	}
-	// add the value to the price
-	price += value_float
-	// convert price back to string
-	priceMap[quantity] = fmt.Sprintf("%.2f", price)
-	return priceMap
 }
 
-func (q *Quotation) getPrimaryAddOn(srv *sheets.Service, spreadsheetId string, range_ string, quotationStringTemplate string, priceMap map[string]string) (string, map[string]string, error) {
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, range_).Do()
+// Instead of using []string, use hashmap keyed by quantity to store the pricing
+func (q *Quotation) getPrintingCost(tables priceTables, catalog *Catalog, range_ string) (map[string]*Pricing, error) {
+	printing, err := tables.printing(range_)
 	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+		return nil, err
 	}
-	var search_string_sizeCategory string = q.SizeCategory
-	var search_string_finishing string = q.PrimaryAddOns.SurfaceProtectionPrinting // This one return empty
+	table, column := printing.lookup(q.NoOfColours, q.Material, q.sizes(catalog))
 
+	// every quantity gets a pricing, unavailable when it has no printing price,
+	// which is every quantity of a combination without printing rows
+	var pricingMap = make(map[string]*Pricing)
 	for _, quantity := range q.Quantity {
-		for _, row := range resp.Values {
-			if len(row) >= 4 {
-				quantity_string := strconv.Itoa(quantity)
-				if err != nil {
-					fmt.Println("Error converting int to string \n", err)
-				}
-				if row[0] == search_string_finishing && row[1] == search_string_sizeCategory && row[2] == quantity_string {
-					quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Primary%s>", quantity_string), fmt.Sprintf("+ RM%s %s", row[3], search_string_finishing), -1)
-					priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-				}
-			}
+		quoted, err := table.priceAt(quantity, column)
+		if err != nil {
+			return nil, err
 		}
+		pricing := q.newPricing(catalog, quantity)
+		pricing.addLineItem(newLineItem(LineItemPrinting, "Printing", "", quoted))
+		pricingMap[strconv.Itoa(quantity)] = pricing
 	}
 
-	return quotationStringTemplate, priceMap, nil
+	return pricingMap, nil
 }
 
-func checkSecondaryAddOnMatch(row []interface{}, search_string_sizeCategory string, quantity_string string, targetSearchString string) (string, bool) {
-	if row[1] == search_string_sizeCategory && row[2] == quantity_string && row[0] == targetSearchString {
-		if row[3] == "not available" {
-			return targetSearchString, true
+func (q *Quotation) getThirdAddOnPrinting(tables priceTables, catalog *Catalog, range_ string, pricingMap map[string]*Pricing) (map[string]*Pricing, error) {
+	// Add Cost for another side, if double side printing
+	if q.printsBothSides() {
+		printing, err := tables.printing(range_)
+		if err != nil {
+			return nil, err
+		}
+		table, column := printing.lookup(q.NoOfColours, q.Material, q.sizes(catalog))
+		for _, quantity := range q.Quantity {
+			pricing, ok := pricingMap[strconv.Itoa(quantity)]
+			if !ok {
+				continue
+			}
+			quoted, err := table.priceAt(quantity, column)
+			if err != nil {
+				return nil, err
+			}
+			pricing.addLineItem(newLineItem(LineItemAnotherSidePrinting, "printing another side", "", quoted))
 		}
-		return targetSearchString, true
 	}
-	return "", false
+	return pricingMap, nil
 }
 
-func (q *Quotation) getSecondaryAddOn(srv *sheets.Service, spreadsheetId string, range_ string, quotationStringTemplate string, priceMap map[string]string) (string, map[string]string, error) {
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, range_).Do()
-	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+// Pricing of each quantity, in the order the quantities were requested
+func (q *Quotation) sortedPricings(pricingMap map[string]*Pricing) []*Pricing {
+	pricings := make([]*Pricing, 0, len(pricingMap))
+	for _, quantity := range q.Quantity {
+		if pricing, ok := pricingMap[strconv.Itoa(quantity)]; ok {
+			pricings = append(pricings, pricing)
+		}
 	}
-	var search_string_sizeCategory string = q.SizeCategory
-	var search_string_windowHoleWithoutTransparentPVCSheet string = q.SecondaryAddOns.WindowHoleWithoutTransparentPVCSheet
-	var search_string_hotstamping string = q.SecondaryAddOns.Hotstamping
-	var search_string_emboss_deboss string = q.SecondaryAddOns.EmbossDeboss
-	var search_string_string string = q.SecondaryAddOns.String
-	var search_string_windowHoleWithTransparentPVCSheet string = q.SecondaryAddOns.WindowHoleWithTransparentPVCSheet
-	// var search_string_spotUV1Side string = q.SecondaryAddOns.SpotUV1Side
+	return pricings
+}
 
-	for _, quantity := range q.Quantity {
-		for _, row := range resp.Values {
-			if len(row) <= 5 && len(row) >= 4 {
-				quantity_string := strconv.Itoa(quantity)
-				if err != nil {
-					fmt.Println("Error converting int to string \n", err)
-				}
-
-				windowHoleWithoutTransparentPVCSheet, ok := checkSecondaryAddOnMatch(row, search_string_windowHoleWithoutTransparentPVCSheet, quantity_string, "window hole without transparent pvc sheet")
-				if ok {
-					quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[3], windowHoleWithoutTransparentPVCSheet, quantity_string), -1)
-					priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-				}
-				hotstamping, ok := checkSecondaryAddOnMatch(row, search_string_hotstamping, quantity_string, "hot stamping")
-				if ok {
-					quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[3], hotstamping, quantity_string), -1)
-					priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-				}
-				emboss_deboss, ok := checkSecondaryAddOnMatch(row, search_string_emboss_deboss, quantity_string, "emboss / deboss")
-				if ok {
-					quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[3], emboss_deboss, quantity_string), -1)
-					priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-				}
-				stringFinishing, ok := checkSecondaryAddOnMatch(row, search_string_string, quantity_string, "string")
-				if ok {
-					quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[3], stringFinishing, quantity_string), -1)
-					priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-				}
-				windowHoleWithTransparentPVCSheet, ok := checkSecondaryAddOnMatch(row, search_string_windowHoleWithTransparentPVCSheet, quantity_string, "window hole with transparent pvc sheet")
-				if ok {
-					quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[3], windowHoleWithTransparentPVCSheet, quantity_string), -1)
-					priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-				}
-				if q.SecondaryAddOns.SpotUV1Side == "spotUV1side" {
-					spotUV1Side, ok := checkSecondaryAddOnMatch(row, search_string_sizeCategory, quantity_string, "spot uv 1side")
-					if ok {
-						if q.ThirdAddOns.IsDoubleSide {
-							quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[4], spotUV1Side, quantity_string), -1)
-							priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[4].(string))
-
-						} else {
-							quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Secondary%s>", row[3], spotUV1Side, quantity_string), -1)
-							priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[3].(string))
-						}
-					}
-				}
+// Price ranges every quotation reads, add-on tables come from the catalog
+const (
+	printingRange    = "printing_raw"
+	anotherSideRange = "third_addon_raw"
+)
+
+// priceRanges lists the price ranges the quotation is priced from
+func (q *Quotation) priceRanges(catalog *Catalog) []string {
+	ranges := []string{printingRange}
+	if q.printsBothSides() {
+		ranges = append(ranges, anotherSideRange)
+	}
+	for _, tier := range []string{AddOnTierPrimary, AddOnTierSecondary, AddOnTierThird} {
+		for _, selected := range q.selectedAddOns(catalog, tier) {
+			if selected.addOnType.formulaFor(selected.option) == nil {
+				ranges = append(ranges, selected.addOnType.Table)
 			}
 		}
 	}
-
-	return quotationStringTemplate, priceMap, nil
+	return uniqueRanges(ranges)
 }
 
-func (q *Quotation) getThirdAddOnPrinting(srv *sheets.Service, spreadsheetId string, range_ string, quotationStringTemplate string, priceMap map[string]string) (string, map[string]string, error) {
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, range_).Do()
+// calculateQuotation fetches every price range the quotation needs at once,
+// then prices it from them
+func (q *Quotation) calculateQuotation(ctx context.Context, src PriceSource, catalog *Catalog) ([]*Pricing, error) {
+	if priceEngine == PriceEngineCost {
+		return q.finishPricings(q.costQuotation(catalog), catalog), nil
+	}
+	tables, err := fetchPriceTables(ctx, src, q.priceRanges(catalog))
 	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+		return nil, fmt.Errorf("unable to get price tables: %w", err)
 	}
+	return q.priceQuotation(tables, catalog)
+}
 
-	// Add Cost for another side, if double side printing
-	var search_string_sizeCategory string = q.SizeCategory
-	var search_str_noOfColours string = q.NoOfColours
-	var search_str_material string = q.Material
-	if q.ThirdAddOns.IsDoubleSide && q.NoOfColours != "0colour" {
-		for _, quantity := range q.Quantity {
-			for _, row := range resp.Values {
-				if len(row) == 6 {
-					quantity_string := strconv.Itoa(quantity)
-					if err != nil {
-						fmt.Println("Error converting int to string \n", err)
-					}
-					if row[1] == search_str_noOfColours && row[2] == search_str_material && row[3] == search_string_sizeCategory && row[4] == quantity_string {
-						quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Third%s>", quantity_string), fmt.Sprintf(" + RM%s %s<Third%s>", row[5], "printing another side", quantity_string), -1)
-						priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[5].(string))
-					}
-				}
-			}
-		}
+// priceQuotation works out the pricing of each quantity from the price
+// tables alone
+func (q *Quotation) priceQuotation(tables priceTables, catalog *Catalog) ([]*Pricing, error) {
+	pricings, err := q.sheetPricings(tables, catalog)
+	if err != nil {
+		return nil, err
 	}
-	return quotationStringTemplate, priceMap, nil
+	return q.finishPricings(pricings, catalog), nil
 }
 
-func (q *Quotation) getThirdAddOnFinishing(srv *sheets.Service, spreadsheetId string, range_ string, quotationStringTemplate string, priceMap map[string]string) (string, map[string]string, error) {
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, range_).Do()
+// sheetPricings looks up the price of every line item in the price tables
+func (q *Quotation) sheetPricings(tables priceTables, catalog *Catalog) ([]*Pricing, error) {
+	pricingMap, err := q.getPrintingCost(tables, catalog, printingRange)
 	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+		return nil, fmt.Errorf("unable to get printing cost: %w", err)
 	}
-	var search_string_sizeCategory string = q.SizeCategory
-	var search_string_finishing string = q.ThirdAddOns.FinishingAnotherSide
-	if q.ThirdAddOns.IsDoubleSide && q.NoOfColours != "0colour" {
-		for _, quantity := range q.Quantity {
-			for _, row := range resp.Values {
-				if len(row) == 5 {
-					quantity_string := strconv.Itoa(quantity)
-					if err != nil {
-						fmt.Println("Error converting int to string \n", err)
-					}
-
-					if row[0] == search_string_finishing && row[1] == search_string_sizeCategory && row[2] == quantity_string {
-						quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Third%s>", quantity_string), fmt.Sprintf(" + RM%s %s", row[4], search_string_finishing), -1)
-						priceMap = q.addTotalPriceInString(priceMap, quantity_string, row[4].(string))
-					}
-				}
-			}
-		}
+	pricingMap, err = q.getAddOns(tables, catalog, AddOnTierPrimary, pricingMap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get primary addon: %w", err)
 	}
-
-	return quotationStringTemplate, priceMap, nil
+	pricingMap, err = q.getAddOns(tables, catalog, AddOnTierSecondary, pricingMap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get secondary addon: %w", err)
+	}
+	pricingMap, err = q.getThirdAddOnPrinting(tables, catalog, anotherSideRange, pricingMap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get third addon printing: %w", err)
+	}
+	pricingMap, err = q.getAddOns(tables, catalog, AddOnTierThird, pricingMap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get third addon finishing: %w", err)
+	}
+	return q.sortedPricings(pricingMap), nil
 }
 
-func (q *Quotation) provideDiscountForReadiedSize(quotationStringTemplate string, priceMap map[string]string) (string, map[string]string, error) {
-	var discountMap = make(map[string]float64)
-	discountMap["A1"] = 300
-	discountMap["A2"] = 250
-	discountMap["A3"] = 200
-	discountMap["A4"] = 150
-	discountMap["A5"] = 100
-
-	if q.ReadiedSize {
-		for _, quantity := range q.Quantity {
-			quantity_string := strconv.Itoa(quantity)
-			// price, err := strconv.ParseFloat(priceMap[quantity_string], 64)
-			// if err != nil {
-			// 	fmt.Println("Error converting string to float \n", err)
-			// }
-			if q.SizeCategory == "A1" {
-				// price = price - discountMap["A1"]
-				quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<ReadiedSizeDiscount%s>", quantity_string), fmt.Sprintf(" - RM%.0f readied size shaped", discountMap["A1"]), -1)
-			} else if q.SizeCategory == "A2" {
-				// price = price - discountMap["A2"]
-				quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<ReadiedSizeDiscount%s>", quantity_string), fmt.Sprintf(" - RM%.0f readied size shaped", discountMap["A2"]), -1)
-			} else if q.SizeCategory == "A3" {
-				// price = price - discountMap["A3"]
-				quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<ReadiedSizeDiscount%s>", quantity_string), fmt.Sprintf(" - RM%.0f readied size shaped", discountMap["A3"]), -1)
-			} else if q.SizeCategory == "A4" {
-				// price = price - discountMap["A4"]
-				quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<ReadiedSizeDiscount%s>", quantity_string), fmt.Sprintf(" - RM%.0f readied size shaped", discountMap["A4"]), -1)
-			} else if q.SizeCategory == "A5" {
-				// price = price - discountMap["A5"]
-				quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<ReadiedSizeDiscount%s>", quantity_string), fmt.Sprintf(" - RM%.0f readied size shaped", discountMap["A5"]), -1)
-			}
-			// fmt.Println("discount given", price)
-			priceMap = q.addTotalPriceInString(priceMap, quantity_string, fmt.Sprintf("-%.2f", discountMap[q.SizeCategory]))
-		}
-	} else {
-		for _, quantity := range q.Quantity {
-			quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<ReadiedSizeDiscount%s>", strconv.Itoa(quantity)), "", -1)
-		}
+// finishPricings takes the discounts off and rounds the totals, whichever
+// engine priced them
+func (q *Quotation) finishPricings(pricings []*Pricing, catalog *Catalog) []*Pricing {
+	q.applyDiscounts(pricings, catalog, time.Now())
+	for _, pricing := range pricings {
+		pricing.roundTotal(moneyRounding)
 	}
-	return quotationStringTemplate, priceMap, nil
+	return pricings
+}
+
+// QuotationHeader holds the descriptive fields shown above the prices
+type QuotationHeader struct {
+	SizeCategory string `json:"sizeCategory"`
+	Machine      string `json:"machine"`
+	PrintSide    string `json:"printSide"`
+	Colour       string `json:"colour"`
+	Shape        string `json:"shape"`
+	Summary      string `json:"summary"`
+	// Blank is the flat blank of a quotation given by box dimensions
+	Blank string `json:"blank,omitempty"`
+	// MaxQuantity is the most pieces the form can price, larger runs are quoted on request
+	MaxQuantity int `json:"maxQuantity"`
 }
 
-func (q *Quotation) addTotalToTemplate(quotationStringTemplate string, priceMap map[string]string) string {
-	priceMapKeys := make([]string, 0, len(priceMap))
-	for k := range priceMap {
-		priceMapKeys = append(priceMapKeys, k)
+// Material followed by every selected add-on, e.g. "art card 350gsm + matt lam 1side + string 12inch"
+func (q *Quotation) getPrintingAddonsLabel(catalog *Catalog) string {
+	var printingAddons string = q.Material
+	for _, tier := range []string{AddOnTierPrimary, AddOnTierSecondary} {
+		for _, selected := range q.selectedAddOns(catalog, tier) {
+			printingAddons += " + " + selected.addOnType.summary(selected.option)
+		}
 	}
-	sort.Strings(priceMapKeys)
-	for _, key := range priceMapKeys {
-		quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Total%s>", key), fmt.Sprintf(" = RM%s\n", priceMap[key]), -1)
+	if q.printsBothSides() {
+		printingAddons += fmt.Sprintf(" + %s ", "printing another side")
+		for _, selected := range q.selectedAddOns(catalog, AddOnTierThird) {
+			printingAddons += " + " + selected.addOnType.summary(selected.option)
+		}
 	}
-	return quotationStringTemplate
+	return printingAddons
 }
 
-func (q *Quotation) addHeaderRemoveTemplate(quotationStringTemplate string) string {
+func (q *Quotation) getHeader(catalog *Catalog) QuotationHeader {
 	var readiedCustomedSizeDisplay string = ""
 	if q.ReadiedSize {
 		readiedCustomedSizeDisplay = "Readied Size Shape"
@@ -362,40 +300,11 @@ func (q *Quotation) addHeaderRemoveTemplate(quotationStringTemplate string) stri
 		readiedCustomedSizeDisplay = "Customed Size Shape"
 	}
 	var singleDoubleSiteDisplay string = ""
-	if q.ThirdAddOns.IsDoubleSide {
+	if q.IsDoubleSide {
 		singleDoubleSiteDisplay = "double side printing"
 	} else {
 		singleDoubleSiteDisplay = "single side printing"
 	}
-	var printingAddons string = q.Material
-	if q.PrimaryAddOns.SurfaceProtectionPrinting != "no finishing (may cause colour rubbing issue)" {
-		printingAddons += fmt.Sprintf(" + %s", q.PrimaryAddOns.SurfaceProtectionPrinting)
-	}
-	if q.SecondaryAddOns.WindowHoleWithoutTransparentPVCSheet != "none" {
-		// Add key for window hole without transparent pvc sheet
-		printingAddons += " + window hole without transparent pvc sheet " + q.SecondaryAddOns.WindowHoleWithoutTransparentPVCSheet
-	}
-	if q.SecondaryAddOns.WindowHoleWithTransparentPVCSheet != "none" {
-		printingAddons += " + window hole with transparent pvc sheet " + q.SecondaryAddOns.WindowHoleWithTransparentPVCSheet
-	}
-	if q.SecondaryAddOns.Hotstamping != "none" {
-		printingAddons += " + hot stamping " + q.SecondaryAddOns.Hotstamping
-	}
-	if q.SecondaryAddOns.EmbossDeboss != "none" {
-		printingAddons += " + emboss / deboss " + q.SecondaryAddOns.EmbossDeboss
-	}
-	if q.SecondaryAddOns.String != "none" {
-		printingAddons += " + string " + q.SecondaryAddOns.String
-	}
-	if q.SecondaryAddOns.SpotUV1Side != "none" {
-		printingAddons += " + spot uv 1side"
-	}
-	if q.ThirdAddOns.IsDoubleSide && q.NoOfColours != "0colour" {
-		printingAddons += fmt.Sprintf(" + %s ", "printing another side")
-		if q.ThirdAddOns.FinishingAnotherSide != "no finishing (may cause colour rubbing issue)" {
-			printingAddons += fmt.Sprintf(" + %s %s", q.ThirdAddOns.FinishingAnotherSide, "another side")
-		}
-	}
 	var colourDisplay string = ""
 	if q.NoOfColours == "0colour" {
 		colourDisplay = "no printing"
@@ -414,324 +323,179 @@ func (q *Quotation) addHeaderRemoveTemplate(quotationStringTemplate string) stri
 	} else {
 		machineDisplay = "Logic Error"
 	}
-
-	header := fmt.Sprintf(`*QUOTATION : BOX PRINTING : %s *
-product : box
-machine : %s
-shape : shape such like open lid / hinged / cake / top bottom / drawer & etc
-material : (see below)
-finishing : die cut / die cut + gluing
-quantity : (see below) For quantity more than 2000pcs, please whatsapp us +60163443238 to request quotation due to paper price fluctuation issue. 
-print side : %s (%s x %s)
-colour : %s
-print process : 6-7days (art card) / 8-10days (with beautify finishing) / 13-14days (carton material) excluded sat, sun, public holiday & pre-preparation works
-
-
-estimated price :
-[ %s ] 
-%s
-`, q.SizeCategory, machineDisplay, singleDoubleSiteDisplay, q.NoOfColours, q.NoOfColours, colourDisplay, readiedCustomedSizeDisplay, printingAddons)
-	quotationStringTemplate = strings.Replace(quotationStringTemplate, "<Header>", header, -1)
-	// remove all the template
-	for _, quantity := range q.Quantity {
-		quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Secondary%s>", strconv.Itoa(quantity)), "", -1)
-		quotationStringTemplate = strings.Replace(quotationStringTemplate, fmt.Sprintf("<Third%s>", strconv.Itoa(quantity)), "", -1)
+	// a size too big for one press goes on the other whatever the quantity
+	spec := catalog.sizeSpec(q.SizeCategory)
+	if !spec.printsOn("digital offset") {
+		machineDisplay = "litho offset"
+	} else if !spec.printsOn("litho offset") {
+		machineDisplay = "digital offset"
+	}
+	return QuotationHeader{
+		SizeCategory: q.SizeCategory,
+		Machine:      machineDisplay,
+		PrintSide:    fmt.Sprintf("%s (%s x %s)", singleDoubleSiteDisplay, q.NoOfColours, q.NoOfColours),
+		Colour:       colourDisplay,
+		Shape:        readiedCustomedSizeDisplay,
+		Summary:      q.getPrintingAddonsLabel(catalog),
+		Blank:        q.blankText(catalog),
+		MaxQuantity:  quantityPricing.largestQuantity(catalog),
 	}
-	return quotationStringTemplate
 }
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
-	}
-	return config.Client(context.Background(), tok)
+func load_env() error {
+	err := godotenv.Load()
+	return err
 }
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+func main() {
+	engine := html.New("./views", ".html")
+	// .env is optional, in a container the settings come from the environment
+	err := load_env()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
 	}
-
-	tok, err := config.Exchange(context.TODO(), authCode)
+	quantityPricing, err = quantityPricingFromEnv()
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		log.Fatalf("Unable to configure quantity pricing: %v", err)
 	}
-	return tok
-}
-
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+	src, oauthStore, err := newPriceSourceFromEnv()
 	if err != nil {
-		return nil, err
+		log.Fatalf("Unable to set up price source: %v", err)
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	cacheTTL, err := durationFromEnv("PRICE_CACHE_TTL", 10*time.Minute)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		log.Fatalf("Unable to configure price cache: %v", err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
-func connectToGoogleSheet() (*sheets.Service, error) {
-	ctx := context.Background()
-	b, err := os.ReadFile("credentials.json")
+	refreshInterval, err := durationFromEnv("PRICE_REFRESH_INTERVAL", cacheTTL)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		log.Fatalf("Unable to configure price cache: %v", err)
 	}
-	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/spreadsheets")
+	moneyRounding, err = moneyRoundingFromEnv()
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		log.Fatalf("Unable to configure money rounding: %v", err)
 	}
-	client := getClient(config)
-	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
-	return srv, err
-}
-
-func load_env() error {
-	err := godotenv.Load()
-	return err
-}
-
-func getValueFromGoogleSheet(srv *sheets.Service, spreadsheetId string, range_ string) ([][]interface{}, error) {
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, range_).Do()
+	priceEngine, err = priceEngineFromEnv()
 	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+		log.Fatalf("Unable to configure the price engine: %v", err)
 	}
-	return resp.Values, err
-}
-
-// func calcuateQuotation(quotation Quotation, value [][]interface{}) (Pricing, error) {
-// 	var search_str_material string
-// 	for i := 0; i < len(materials); i++ {
-// 		if materials[i] == quotation.Material {
-// 			search_str_material = quotation.Material
-// 			break
-// 		}
-// 	}
-// 	var colourSearchStr string
-// 	if quotation.NoOfColours <= 1 {
-// 		colourSearchStr = fmt.Sprintf("%d%s", quotation.NoOfColours, "colour")
-// 	} else {
-// 		colourSearchStr = fmt.Sprintf("%d%s", quotation.NoOfColours, "colours")
-// 	}
-// 	noOfQuantity := len(quotation.Quantity)
-// 	// search_str_material = strings.Replace(search_str_material, "_", " ", -1)
-// 	prices := Pricing{
-// 		Quantity:     quotation.Quantity[0],
-// 		NoOfColours:  quotation.NoOfColours,
-// 		ReadiedSize:  quotation.ReadiedSize,
-// 		PriceLabel:   search_str_material,
-// 		Price:        []string{},
-// 		Header:       fmt.Sprintf("Quotation for %s, %s \n", search_str_material, quotation.SizeCategory),
-// 		IsDoubleSide: quotation.IsDoubleSide,
-// 		SizeCategory: quotation.SizeCategory,
-// 	}
-// 	tempQuantitySearchIdx := 0
-// 	for _, row := range value {
-// 		if len(row) == 6 {
-// 			quantity_search := strconv.Itoa(quotation.Quantity[tempQuantitySearchIdx])
-// 			if row[2] == search_str_material && row[3] == quotation.SizeCategory && row[1] == colourSearchStr && row[4] == quantity_search {
-// 				if row[5] == "" || row[5] == "not available" {
-// 					row[5] = " Not Available"
-// 				}
-// 				prices.Price = append(prices.Price, fmt.Sprintf("%s pcs: RM%s Printing", row[4], row[5]))
-// 				tempQuantitySearchIdx++
-// 				if len(prices.Price) == noOfQuantity {
-// 					break
-// 				}
-// 			}
-// 		}
-// 	}
-// 	return prices, nil
-// }
-
-// func calculateAddOn(price Pricing, addOns []string, value [][]interface{}, quotation Quotation) (Pricing, error) {
-// 	for _, addOn := range addOns {
-// 		price.PriceLabel += fmt.Sprintf(" + %s", addOn)
-// 		tempQuantitySearchIdx := 0
-// 		if addOn == "no finishing (may cause colour rubbing issue)" {
-// 			for i := 0; i < len(price.Price); i++ {
-// 				price.Price[i] += " + Not Available (no finishing)"
-// 			}
-// 			continue
-// 		}
-// 		// If strings contains _ mean is secondary finishing
-// 		if strings.Contains(addOn, "_") {
-// 			//split string by dash
-// 			addOnType := strings.Split(addOn, "_")[0]
-// 			spec := strings.Split(addOn, "_")[1]
-// 			for _, row := range value {
-// 				if len(row) != 1 && len(row) != 0 {
-// 					quantity_search := strconv.Itoa(quotation.Quantity[tempQuantitySearchIdx])
-// 					if row[0] == addOnType && row[1] == spec && row[2] == quantity_search {
-// 						if quotation.IsDoubleSide {
-// 							if len(row) != 5 {
-// 								price.Price[tempQuantitySearchIdx] += fmt.Sprintf(" + Not Available (%s)", addOnType)
-// 							} else {
-// 								if row[4] == "" || row[4] == "not available" {
-// 									row[4] = fmt.Sprintf(" + Not Available (%s)", addOnType)
-// 								}
-// 								price.Price[tempQuantitySearchIdx] += fmt.Sprintf(" + RM %s (%s)", row[4], addOnType)
-// 							}
-
-// 						} else {
-// 							if row[3] == "" {
-// 								row[3] = "Not Available"
-// 							}
-// 							price.Price[tempQuantitySearchIdx] += fmt.Sprintf(" + RM%s", row[3])
-// 						}
-// 						if tempQuantitySearchIdx == len(quotation.Quantity)-1 {
-// 							break
-// 						}
-// 						tempQuantitySearchIdx++
-// 					}
-// 				}
-// 			}
-// 			continue
-// 		} else {
-// 			for _, row := range value {
-// 				if len(row) != 1 && len(row) != 0 {
-// 					quantity_search := strconv.Itoa(quotation.Quantity[tempQuantitySearchIdx])
-// 					if row[0] == addOn && row[1] == price.SizeCategory && row[2] == quantity_search {
-// 						if quotation.IsDoubleSide {
-// 							if row[4] == "" {
-// 								row[4] = fmt.Sprintf(" + Not Available (%s)", addOn)
-// 							}
-// 							price.Price[tempQuantitySearchIdx] += fmt.Sprintf(" + RM%s (%s)", row[4], addOn)
-// 						} else {
-// 							if row[3] == "" {
-// 								row[3] = fmt.Sprintf(" + Not Available (%s)", addOn)
-// 							}
-// 							price.Price[tempQuantitySearchIdx] += fmt.Sprintf(" + RM%s (%s)", row[3], addOn)
-// 						}
-// 						if tempQuantitySearchIdx == len(quotation.Quantity)-1 {
-// 							break
-// 						}
-// 						tempQuantitySearchIdx++
-// 					}
-// 				}
-// 			}
-// 		}
-// 	}
-// 	return price, nil
-// }
-
-func main() {
-	engine := html.New("./views", ".html")
-	err := load_env()
+	priceFetchTimeout, err = durationFromEnv("PRICE_FETCH_TIMEOUT", priceFetchTimeout)
+	if err != nil {
+		log.Fatalf("Unable to configure price fetching: %v", err)
+	}
+	cachedRanges := priceRanges
+	// a catalog tab is cached with the prices, so reloading the catalog
+	// doesn't go back to the sheet
+	if range_, ok := catalogSheetRange(); ok {
+		cachedRanges = append(append([]string{}, priceRanges...), range_)
+	}
+	priceCache := NewCachedPriceSource(src, cachedRanges, cacheTTL)
+	if err := priceCache.Refresh(); err != nil {
+		log.Printf("Unable to preload price tables, will retry on first quotation: %v", err)
+	}
+	priceCache.Start(refreshInterval, nil)
+	productCatalog, err = newCatalogStoreFromEnv(priceCache)
+	if err != nil {
+		log.Fatalf("Unable to set up catalog: %v", err)
+	}
+	if _, err := productCatalog.Reload(); err != nil {
+		log.Printf("Unable to load catalog, using the built-in catalog until it loads: %v", err)
+	}
+	catalogInterval, err := durationFromEnv("CATALOG_RELOAD_INTERVAL", 30*time.Second)
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		log.Fatalf("Unable to configure catalog reload: %v", err)
 	}
-	spreadsheetId := os.Getenv("SPREADSHEET_ID")
-	srv, err := connectToGoogleSheet()
+	productCatalog.Start(catalogInterval, nil)
+	quoteStore, err := OpenQuotationStore(getEnvOrDefault("QUOTE_DB_PATH", "quotations.db"))
 	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
+		log.Fatalf("Unable to open quotation store: %v", err)
+	}
+	defer quoteStore.Close()
+	if err := quoteStore.addMissingHeaders(productCatalog.Current()); err != nil {
+		log.Fatalf("Unable to update quotation store: %v", err)
 	}
 	app := fiber.New(fiber.Config{
-		Views: engine,
+		Views:        engine,
+		ErrorHandler: errorHandler,
 	})
+	app.Use(recover.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
-		AllowMethods: "POST",
+		AllowMethods: "GET,POST",
 		AllowHeaders: "Origin, Content-Type, Accept",
 	}))
+	admin := app.Group("/admin", adminMiddleware(), adminCSRF())
+	registerPriceCacheRoutes(admin, priceCache)
+	registerCatalogAdminRoutes(admin, productCatalog)
+	registerPriceComparisonRoutes(admin, priceCache, productCatalog)
+	registerGoogleOAuthRoutes(app, admin, oauthStore, priceCache)
+
 	app.Get("/", func(c *fiber.Ctx) error {
 		// Render index template
+		catalog := productCatalog.Current()
 		return c.Render("index", fiber.Map{
-			"host":                                 os.Getenv("HOST"),
-			"port":                                 os.Getenv("PORT"),
-			"materials":                            materials,
-			"categorySize":                         categorySize,
-			"quantityRange":                        quantityRange,
-			"surfaceProtectionPrinting":            surfaceProtectionPrinting,
-			"windowHoleWithoutTransparentPVCSheet": windowHoleWithoutTransparentPVCSheet,
-			"windowHoleWithTransparentPVCSheet":    windowHoleWithTransparentPVCSheet,
-			"hotstamping":                          hotstamping,
-			"embossDeboss":                         emboss_deboss,
-			"stringFinishing":                      stringFinishing,
-			"finishingAnotherSide":                 finishingAnotherSide,
-			"noOfColours":                          noOfColours,
-			"isReadiedSize":                        isReadiedSize,
+			"host":                  os.Getenv("HOST"),
+			"port":                  os.Getenv("PORT"),
+			"materials":             enabledOptions(catalog.Materials),
+			"categorySize":          enabledOptions(catalog.SizeCategories),
+			"quantityRange":         enabledOptions(catalog.Quantities),
+			"quantities":            catalog.quantities(),
+			"primaryAddOns":         catalog.addOnGroups(AddOnTierPrimary),
+			"secondaryAddOns":       catalog.addOnGroups(AddOnTierSecondary),
+			"thirdAddOns":           catalog.addOnGroups(AddOnTierThird),
+			"rules":                 catalog.Rules,
+			"sizes":                 catalog.formSizes(),
+			"boxStyles":             catalog.BoxStyles,
+			"noOfColours":           enabledOptions(catalog.NoOfColours),
+			"isReadiedSize":         enabledOptions(catalog.Shapes),
+			"customerTiers":         enabledOptions(catalog.CustomerTiers),
+			"interpolateQuantities": quantityPricing.interpolates(),
 		})
 	})
 
 	app.Post("/getQuotation", func(c *fiber.Ctx) error {
 		quotation := new(Quotation)
 		if err := c.BodyParser(quotation); err != nil {
-			return err
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
-		fmt.Println("Quotation: ", quotation)
-		quotationStringTemplate, priceMap, err := quotation.getPrintingCost(srv, spreadsheetId, "printing_raw")
-		if err != nil {
-			fmt.Println("Unable to get printing cost")
+		// one catalog for the whole request, however often it reloads
+		catalog := productCatalog.Current()
+		quotation.normaliseAddOns(catalog)
+		if errs := quotation.validate(catalog); errs != nil {
+			return validationErrorResponse(c, errs)
 		}
-		quotationStringTemplate, priceMap, err = quotation.getPrimaryAddOn(srv, spreadsheetId, "primary_secondary_addon_raw", quotationStringTemplate, priceMap)
+		_, renderer, err := selectRenderer(c)
 		if err != nil {
-			fmt.Println("Unable to get primary addon")
+			return err
 		}
-		quotationStringTemplate, priceMap, err = quotation.getSecondaryAddOn(srv, spreadsheetId, "primary_secondary_addon_raw", quotationStringTemplate, priceMap)
+		pricings, err := quotation.calculateQuotation(c.UserContext(), priceCache, catalog)
 		if err != nil {
-			fmt.Println("Unable to get secondary addon")
+			return err
 		}
-		quotationStringTemplate, priceMap, err = quotation.getThirdAddOnPrinting(srv, spreadsheetId, "third_addon_raw", quotationStringTemplate, priceMap)
+		record, err := quotation.newQuotationRecord(catalog, pricings)
 		if err != nil {
-			fmt.Println("Unable to get Third addon printing")
+			return err
 		}
-		quotationStringTemplate, priceMap, err = quotation.getThirdAddOnFinishing(srv, spreadsheetId, "primary_secondary_addon_raw", quotationStringTemplate, priceMap)
-		if err != nil {
-			fmt.Println("Unable to get secondary addon finishing")
+		if err := quoteStore.Save(record); err != nil {
+			return err
 		}
-		quotationStringTemplate, priceMap, err = quotation.provideDiscountForReadiedSize(quotationStringTemplate, priceMap)
+		log.Printf("Saved quotation %s", record.Number)
+		text, err := renderer.Render(record)
 		if err != nil {
-			fmt.Println("Unable to provide discount for readied size")
-		}
-		quotationStringTemplate = quotation.addTotalToTemplate(quotationStringTemplate, priceMap)
-		quotationStringTemplate = quotation.addHeaderRemoveTemplate(quotationStringTemplate)
-		for key, value := range priceMap {
-			fmt.Println("Key:", key, "Value:", value)
+			return err
 		}
-		fmt.Println(quotationStringTemplate)
-		return c.SendString(quotationStringTemplate)
+		c.Set("X-Quotation-Number", record.Number)
+		c.Set("X-Quotation-PDF", record.pdfPath())
+		c.Set(fiber.HeaderContentType, renderer.ContentType())
+		return c.SendString(text)
 	})
 
-	log.Fatal(app.ListenTLS(":8000", "cert.pem", "key.pem"))
+	api := app.Group("/api/v1")
+	registerQuotationAPIRoutes(api, priceCache, quoteStore)
+	registerCatalogAPIRoutes(api, productCatalog)
+	registerImpositionRoutes(api, productCatalog)
+	registerQuotationStoreRoutes(api, quoteStore)
+	registerQuotationPDFRoutes(app, quoteStore)
 
-}
+	log.Fatal(app.ListenTLS(":8000", "cert.pem", "key.pem"))
 
-func categorizeMachineType(pricing Pricing, quotation Quotation) (Pricing, error) {
-	if (quotation.Quantity[0] <= 500) && (quotation.Quantity[len(quotation.Quantity)-1] <= 500) {
-		pricing.PriceLabel = "machine type: digital offset \n" + pricing.PriceLabel
-		return pricing, nil
-	}
-	if (quotation.Quantity[0] > 500) && (quotation.Quantity[len(quotation.Quantity)-1] <= 5000) {
-		pricing.PriceLabel = "machine type: litho offset \n" + pricing.PriceLabel
-		return pricing, nil
-	}
-	if (quotation.Quantity[0] <= 500) && (quotation.Quantity[len(quotation.Quantity)-1] <= 5000) {
-		pricing.PriceLabel = "machine type: litho offset (1000pcs & above), digital offset (10-500pcs) \n" + pricing.PriceLabel
-		return pricing, nil
-	}
-	return pricing, errors.New("unable to categorize machine type")
 }
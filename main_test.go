@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testCatalog is the built-in catalog, parsed afresh so a test can change it
+func testCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	catalog, err := parseCatalog(defaultCatalogJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return catalog
+}
+
+// testPrices has 4colours art card 350gsm on A4 at 100, 200 and 300 pcs, with
+// the gaps a real sheet has: blank and "not available" cells, and a row cut
+// short before its double side price
+func testPrices() *MemoryPriceSource {
+	return NewMemoryPriceSource(map[string][][]interface{}{
+		printingRange: {
+			{"1", "4colours", "art card 350gsm", "A4", "100", "100.00"},
+			{"2", "4colours", "art card 350gsm", "A4", "200", "180.00"},
+			{"3", "4colours", "art card 350gsm", "A4", "300", ""},
+		},
+		anotherSideRange: {
+			{"1", "4colours", "art card 350gsm", "A4", "100", "60.00"},
+			{"2", "4colours", "art card 350gsm", "A4", "200", "110.00"},
+		},
+		"primary_secondary_addon_raw": {
+			{"matt lam 1side", "A4", "100", "20.00"},
+			{"matt lam 1side", "A4", "200", "35.00"},
+			{"hot stamping", "within 16 square inch", "100", "80.00"},
+			{"hot stamping", "within 16 square inch", "200", "not available"},
+			{"spot uv 1side", "A4", "100", "30.00", "55.00"},
+			{"spot uv 1side", "A4", "200", "50.00"},
+		},
+	})
+}
+
+func mustFetch(t *testing.T, src PriceSource, ranges []string) priceTables {
+	t.Helper()
+	tables, err := fetchPriceTables(context.Background(), src, ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func testQuotation(addOns ...SelectedAddOn) *Quotation {
+	return &Quotation{SizeCategory: "A4", Material: "art card 350gsm", NoOfColours: "4colours", Quantity: []int{100, 200}, AddOns: addOns}
+}
+
+// quotedTotals is the total of each quantity, or the reasons it can't be quoted
+func quotedTotals(pricings []*Pricing) map[int]interface{} {
+	totals := make(map[int]interface{}, len(pricings))
+	for _, pricing := range pricings {
+		if pricing.Available {
+			totals[pricing.Quantity] = pricing.Total.String()
+		} else {
+			totals[pricing.Quantity] = pricing.Reasons
+		}
+	}
+	return totals
+}
+
+func TestCalculateQuotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		quotation func() *Quotation
+		want      map[int]interface{}
+	}{
+		{
+			name:      "printing",
+			quotation: func() *Quotation { return testQuotation() },
+			want:      map[int]interface{}{100: "100.00", 200: "180.00"},
+		},
+		{
+			name: "primary add-on",
+			quotation: func() *Quotation {
+				return testQuotation(SelectedAddOn{"surfaceProtectionPrinting", "matt lam 1side"})
+			},
+			want: map[int]interface{}{100: "120.00", 200: "215.00"},
+		},
+		{
+			name: "blank printing price",
+			quotation: func() *Quotation {
+				q := testQuotation()
+				q.Quantity = []int{100, 300}
+				return q
+			},
+			want: map[int]interface{}{100: "100.00", 300: []string{"Printing is not available for 300 pcs"}},
+		},
+		{
+			name: "quantity without a printing row",
+			quotation: func() *Quotation {
+				q := testQuotation()
+				q.Quantity = []int{100, 500}
+				return q
+			},
+			want: map[int]interface{}{100: "100.00", 500: []string{"Printing is not available for 500 pcs"}},
+		},
+		{
+			name: "combination without printing rows",
+			quotation: func() *Quotation {
+				q := testQuotation()
+				q.SizeCategory = "A5"
+				return q
+			},
+			want: map[int]interface{}{
+				100: []string{"Printing is not available for 100 pcs"},
+				200: []string{"Printing is not available for 200 pcs"},
+			},
+		},
+		{
+			name: "not available add-on",
+			quotation: func() *Quotation {
+				return testQuotation(SelectedAddOn{"hotstamping", "within 16 square inch"})
+			},
+			want: map[int]interface{}{100: "180.00", 200: []string{"hot stamping within 16 square inch is not available for 200 pcs"}},
+		},
+		{
+			name: "add-on option without rows",
+			quotation: func() *Quotation {
+				return testQuotation(SelectedAddOn{"hotstamping", "within 24 square inch"})
+			},
+			want: map[int]interface{}{
+				100: []string{"hot stamping within 24 square inch is not available for 100 pcs"},
+				200: []string{"hot stamping within 24 square inch is not available for 200 pcs"},
+			},
+		},
+		{
+			name: "double side row cut short",
+			quotation: func() *Quotation {
+				q := testQuotation(SelectedAddOn{"surfaceProtectionPrinting", "matt lam 1side"}, SelectedAddOn{"spotUV1Side", "spotUV1Side"})
+				q.IsDoubleSide = true
+				return q
+			},
+			want: map[int]interface{}{100: "235.00", 200: []string{"spot uv 1side is not available for 200 pcs"}},
+		},
+	}
+	catalog := testCatalog(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pricings, err := tt.quotation().calculateQuotation(context.Background(), testPrices(), catalog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := quotedTotals(pricings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateQuotationLineItems(t *testing.T) {
+	q := testQuotation(SelectedAddOn{"hotstamping", "within 24 square inch"}, SelectedAddOn{"surfaceProtectionPrinting", "matt lam 1side"})
+	pricings, err := q.calculateQuotation(context.Background(), testPrices(), testCatalog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an add-on without a price is still on the quotation, never skipped
+	var kinds []string
+	for _, item := range pricings[0].LineItems {
+		kinds = append(kinds, item.Kind+" "+item.name())
+	}
+	want := []string{"printing Printing", "primaryAddOn matt lam 1side", "secondaryAddOn hot stamping within 24 square inch"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("line items %v, want %v", kinds, want)
+	}
+	if source := pricings[0].LineItems[1].Source; source == nil || *source != (SourceRow{Range: "primary_secondary_addon_raw", Row: 1}) {
+		t.Errorf("matt lam source %v, want row 1 of primary_secondary_addon_raw", source)
+	}
+}
+
+func TestCalculateQuotationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices func() *MemoryPriceSource
+		q      *Quotation
+		kind   error
+	}{
+		{"missing range", func() *MemoryPriceSource { return NewMemoryPriceSource(nil) }, testQuotation(), ErrRangeMissing},
+		{"malformed price", func() *MemoryPriceSource {
+			src := testPrices()
+			src.SetRange(printingRange, [][]interface{}{{"1", "4colours", "art card 350gsm", "A4", "100", "RM1.234"}})
+			return src
+		}, testQuotation(), ErrMalformedRow},
+	}
+	catalog := testCatalog(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.q.calculateQuotation(context.Background(), tt.prices(), catalog)
+			if !errors.Is(err, tt.kind) {
+				t.Errorf("got %v, want %v", err, tt.kind)
+			}
+		})
+	}
+}
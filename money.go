@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in sen, so prices add up exactly
+type Money int64
+
+const (
+	Sen     Money = 1
+	Ringgit Money = 100
+)
+
+// ParseMoney reads an amount such as "300", "1,250.50", "RM45.5" or "-150.00".
+// Anything else, including more than two decimal places, is an error rather
+// than a guess.
+func ParseMoney(s string) (Money, error) {
+	text := strings.TrimSpace(s)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	if len(text) >= 2 && strings.EqualFold(text[:2], "RM") {
+		text = strings.TrimSpace(text[2:])
+	}
+	whole, fraction, hasFraction := strings.Cut(text, ".")
+	if hasFraction && (len(fraction) == 0 || len(fraction) > 2 || !isDigits(fraction)) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if !validWholeRinggit(whole) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	ringgit, err := strconv.ParseInt(strings.ReplaceAll(whole, ",", ""), 10, 64)
+	if err != nil || ringgit > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	sen, _ := strconv.ParseInt((fraction + "00")[:2], 10, 64)
+	m := Money(ringgit)*Ringgit + Money(sen)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// validWholeRinggit accepts "1250" or "1,250", but not "12,50" or ""
+func validWholeRinggit(whole string) bool {
+	if !strings.Contains(whole, ",") {
+		return isDigits(whole)
+	}
+	groups := strings.Split(whole, ",")
+	if len(groups[0]) == 0 || len(groups[0]) > 3 || !isDigits(groups[0]) {
+		return false
+	}
+	for _, group := range groups[1:] {
+		if len(group) != 3 || !isDigits(group) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String is the amount without a currency, e.g. "1250.50" or "-150.00", as
+// it goes in JSON
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/Ringgit, m%Ringgit)
+}
+
+// Format is how every price is shown, e.g. "RM1250.50", with discounts as
+// "- RM150.00" rather than "RM-150.00"
+func (m Money) Format() string {
+	if m < 0 {
+		return "- RM" + (-m).String()
+	}
+	return "RM" + m.String()
+}
+
+func (m Money) Ringgit() float64 {
+	return float64(m) / float64(Ringgit)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON takes the amount as text, as it is written, or as a number
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(b, &number); err != nil {
+			return fmt.Errorf("invalid amount %s", b)
+		}
+		text = number.String()
+	}
+	parsed, err := ParseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Ways to round a fraction of a sen
+const (
+	RoundHalfUp   = "half_up"
+	RoundHalfEven = "half_even"
+	RoundUp       = "up"
+	RoundDown     = "down"
+)
+
+// Ways to round totals
+const (
+	TotalRoundingSen = "sen"
+	// TotalRoundingCash rounds to the nearest 5 sen, as Malaysian cash
+	// payments are: 1-2 sen down, 3-4 sen up to 5, 6-7 sen down to 5, 8-9 sen up
+	TotalRoundingCash = "cash"
+)
+
+type MoneyRounding struct {
+	// Mode rounds estimated prices, which can come out in fractions of a sen
+	Mode  string
+	Total string
+}
+
+// moneyRounding is set from the environment at start up
+var moneyRounding = MoneyRounding{Mode: RoundHalfUp, Total: TotalRoundingSen}
+
+// moneyRoundingFromEnv reads MONEY_ROUNDING and TOTAL_ROUNDING
+func moneyRoundingFromEnv() (MoneyRounding, error) {
+	r := MoneyRounding{
+		Mode:  strings.ToLower(getEnvOrDefault("MONEY_ROUNDING", RoundHalfUp)),
+		Total: strings.ToLower(getEnvOrDefault("TOTAL_ROUNDING", TotalRoundingSen)),
+	}
+	switch r.Mode {
+	case RoundHalfUp, RoundHalfEven, RoundUp, RoundDown:
+	default:
+		return r, fmt.Errorf("MONEY_ROUNDING must be %s, %s, %s or %s, got %q", RoundHalfUp, RoundHalfEven, RoundUp, RoundDown, r.Mode)
+	}
+	switch r.Total {
+	case TotalRoundingSen, TotalRoundingCash:
+	default:
+		return r, fmt.Errorf("TOTAL_ROUNDING must be %s or %s, got %q", TotalRoundingSen, TotalRoundingCash, r.Total)
+	}
+	return r, nil
+}
+
+// fromRinggit rounds an amount worked out in ringgit to the sen
+func (r MoneyRounding) fromRinggit(ringgit float64) Money {
+	// drop the float error first, so 1.005 ringgit is 100.5 sen and not 100.49999...
+	sen := math.Round(ringgit*float64(Ringgit)*1e6) / 1e6
+	switch r.Mode {
+	case RoundHalfEven:
+		sen = math.RoundToEven(sen)
+	case RoundUp:
+		sen = math.Ceil(sen)
+	case RoundDown:
+		sen = math.Floor(sen)
+	default:
+		sen = math.Round(sen)
+	}
+	return Money(sen)
+}
+
+// roundTotal returns total rounded as r says
+func (r MoneyRounding) roundTotal(total Money) Money {
+	if r.Total != TotalRoundingCash {
+		return total
+	}
+	if total < 0 {
+		return -r.roundTotal(-total)
+	}
+	remainder := total % (5 * Sen)
+	if remainder < 3 {
+		return total - remainder
+	}
+	return total - remainder + 5*Sen
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{"300", 300 * Ringgit, false},
+		{"1,250.50", 125050, false},
+		{"RM45.5", 4550, false},
+		{"rm 45.05", 4505, false},
+		{" 0.07 ", 7, false},
+		{"-150.00", -15000, false},
+		{"1,234,567", 123456700, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"1.234", 0, true},
+		{"1.", 0, true},
+		{"12,50", 0, true},
+		{",100", 0, true},
+		{"1.2.3", 0, true},
+		{"1e3", 0, true},
+		{"--1", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		m      Money
+		str    string
+		format string
+	}{
+		{125050, "1250.50", "RM1250.50"},
+		{5, "0.05", "RM0.05"},
+		{0, "0.00", "RM0.00"},
+		{-15000, "-150.00", "- RM150.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.str {
+			t.Errorf("%d String() = %q, want %q", tt.m, got, tt.str)
+		}
+		if got := tt.m.Format(); got != tt.format {
+			t.Errorf("%d Format() = %q, want %q", tt.m, got, tt.format)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var v struct {
+		Text   Money `json:"text"`
+		Number Money `json:"number"`
+	}
+	if err := json.Unmarshal([]byte(`{"text": "RM1,250.50", "number": 45.5}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Text != 125050 || v.Number != 4550 {
+		t.Errorf("got %d and %d", v.Text, v.Number)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"text":"1250.50","number":"45.50"}` {
+		t.Errorf("marshalled %s", b)
+	}
+	if err := json.Unmarshal([]byte(`{"text": "1.005"}`), &v); err == nil {
+		t.Error("a fraction of a sen was accepted")
+	}
+}
+
+func TestFromRinggit(t *testing.T) {
+	tests := []struct {
+		ringgit float64
+		mode    string
+		want    Money
+	}{
+		{1.005, RoundHalfUp, 101},
+		{1.005, RoundHalfEven, 100},
+		{1.015, RoundHalfEven, 102},
+		{1.001, RoundUp, 101},
+		{1.009, RoundDown, 100},
+		// float error is dropped before rounding, 0.1 * 3 is 30 sen not 30.000000000000004
+		{0.1 * 3, RoundUp, 30},
+		{-1.005, RoundHalfUp, -101},
+	}
+	for _, tt := range tests {
+		if got := (MoneyRounding{Mode: tt.mode}).fromRinggit(tt.ringgit); got != tt.want {
+			t.Errorf("fromRinggit(%v) %s = %d, want %d", tt.ringgit, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTotal(t *testing.T) {
+	cash := MoneyRounding{Mode: RoundHalfUp, Total: TotalRoundingCash}
+	tests := []struct {
+		total Money
+		want  Money
+	}{
+		{10000, 10000},
+		{10001, 10000},
+		{10002, 10000},
+		{10003, 10005},
+		{10004, 10005},
+		{10006, 10005},
+		{10007, 10005},
+		{10008, 10010},
+		{10009, 10010},
+		{-10003, -10005},
+	}
+	for _, tt := range tests {
+		if got := cash.roundTotal(tt.total); got != tt.want {
+			t.Errorf("cash roundTotal(%d) = %d, want %d", tt.total, got, tt.want)
+		}
+	}
+	if got := (MoneyRounding{Total: TotalRoundingSen}).roundTotal(10003); got != 10003 {
+		t.Errorf("sen roundTotal(10003) = %d", got)
+	}
+}
+
+func TestPricingRoundTotal(t *testing.T) {
+	pricing := &Pricing{Quantity: 100, Availability: available()}
+	pricing.addLineItem(LineItem{Kind: LineItemPrinting, Label: "Printing", Price: 10003, Availability: available()})
+	pricing.roundTotal(MoneyRounding{Mode: RoundHalfUp, Total: TotalRoundingCash})
+	// the difference is its own line item, so the breakdown still adds up
+	if pricing.Total != 10005 || len(pricing.LineItems) != 2 || pricing.LineItems[1].Kind != LineItemRounding || pricing.LineItems[1].Price != 2 {
+		t.Errorf("got total %d with %+v", pricing.Total, pricing.LineItems)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+)
+
+var errGoogleNotConnected = errors.New("google account is not connected, an admin must connect it at /admin/google")
+
+const oauthStateTTL = 10 * time.Minute
+
+// oauthTokenStore holds the installed-app OAuth token. It is the TokenSource of
+// the Sheets client, so connecting or disconnecting from the admin page takes
+// effect without restarting the server. Refreshed tokens are written back to disk.
+type oauthTokenStore struct {
+	config *oauth2.Config
+	path   string
+
+	mu     sync.Mutex
+	token  *oauth2.Token
+	scopes []string
+	states map[string]time.Time
+}
+
+// savedToken is the token file format. It is a plain oauth2.Token with the
+// granted scopes alongside, so older token.json files still load.
+type savedToken struct {
+	oauth2.Token
+	Scope string `json:"scope,omitempty"`
+}
+
+type OAuthStatus struct {
+	Connected       bool      `json:"connected"`
+	Expiry          time.Time `json:"expiry"`
+	Expired         bool      `json:"expired"`
+	HasRefreshToken bool      `json:"hasRefreshToken"`
+	Scopes          []string  `json:"scopes"`
+	TokenFile       string    `json:"tokenFile"`
+	RedirectURL     string    `json:"redirectURL"`
+}
+
+func newOAuthTokenStore(config *oauth2.Config, path string) *oauthTokenStore {
+	s := &oauthTokenStore{config: config, path: path, states: make(map[string]time.Time)}
+	tok, scopes, err := loadSavedToken(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Ignoring unreadable token file %s: %v", path, err)
+		}
+		log.Printf("Google is not connected, visit /admin/google to connect")
+		return s
+	}
+	s.token, s.scopes = tok, scopes
+	return s
+}
+
+// Token implements oauth2.TokenSource
+func (s *oauthTokenStore) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil {
+		return nil, errGoogleNotConnected
+	}
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	tok, err := s.config.TokenSource(context.Background(), s.token).Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh google token: %w", err)
+	}
+	s.token = tok
+	if err := saveToken(s.path, tok, s.scopes); err != nil {
+		log.Printf("Unable to save refreshed token: %v", err)
+	}
+	return tok, nil
+}
+
+// authCodeURL starts a connect flow and returns the Google consent page to send the admin to
+func (s *oauthTokenStore) authCodeURL() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	for st, expiry := range s.states {
+		if time.Now().After(expiry) {
+			delete(s.states, st)
+		}
+	}
+	s.states[state] = time.Now().Add(oauthStateTTL)
+	s.mu.Unlock()
+
+	// force the consent screen so Google always hands back a refresh token
+	return s.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce), nil
+}
+
+// exchange finishes a connect flow started by authCodeURL and persists the token
+func (s *oauthTokenStore) exchange(ctx context.Context, state string, code string) error {
+	s.mu.Lock()
+	expiry, ok := s.states[state]
+	delete(s.states, state)
+	s.mu.Unlock()
+	if !ok || time.Now().After(expiry) {
+		return fiber.NewError(fiber.StatusBadRequest, "unknown or expired oauth state, start again from /admin/google")
+	}
+
+	tok, err := s.config.Exchange(ctx, code)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadGateway, fmt.Sprintf("unable to exchange authorization code: %v", err))
+	}
+	scopes := s.config.Scopes
+	if granted, ok := tok.Extra("scope").(string); ok && granted != "" {
+		scopes = strings.Fields(granted)
+	}
+	if err := saveToken(s.path, tok, scopes); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.token, s.scopes = tok, scopes
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *oauthTokenStore) disconnect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token, s.scopes = nil, nil
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove token file: %w", err)
+	}
+	return nil
+}
+
+func (s *oauthTokenStore) status() OAuthStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := OAuthStatus{TokenFile: s.path, RedirectURL: s.config.RedirectURL, Scopes: []string{}}
+	if s.token == nil {
+		return status
+	}
+	status.Connected = true
+	status.Expiry = s.token.Expiry
+	status.Expired = !s.token.Valid()
+	status.HasRefreshToken = s.token.RefreshToken != ""
+	status.Scopes = s.scopes
+	return status
+}
+
+func loadSavedToken(path string) (*oauth2.Token, []string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var saved savedToken
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, nil, err
+	}
+	return &saved.Token, strings.Fields(saved.Scope), nil
+}
+
+func saveToken(path string, token *oauth2.Token, scopes []string) error {
+	log.Printf("Saving credential file to: %s", path)
+	b, err := json.Marshal(savedToken{Token: *token, Scope: strings.Join(scopes, " ")})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %w", err)
+	}
+	return nil
+}
+
+// registerGoogleOAuthRoutes adds the admin page for the Google connection and the
+// OAuth callback. store is nil when GOOGLE_AUTH_MODE is not oauth.
+func registerGoogleOAuthRoutes(app *fiber.App, admin fiber.Router, store *oauthTokenStore, cache *CachedPriceSource) {
+	admin.Get("/google", func(c *fiber.Ctx) error {
+		data := fiber.Map{
+			"mode":  getEnvOrDefault("GOOGLE_AUTH_MODE", authModeOAuth),
+			"cache": cache.Status(),
+			"csrf":  c.Locals("csrf"),
+		}
+		if store != nil {
+			data["oauth"] = store.status()
+		}
+		return c.Render("admin", data)
+	})
+	if store == nil {
+		return
+	}
+	admin.Get("/google/status", func(c *fiber.Ctx) error {
+		return c.JSON(store.status())
+	})
+	admin.Get("/google/connect", func(c *fiber.Ctx) error {
+		url, err := store.authCodeURL()
+		if err != nil {
+			return err
+		}
+		return c.Redirect(url)
+	})
+	admin.Post("/google/disconnect", func(c *fiber.Ctx) error {
+		if err := store.disconnect(); err != nil {
+			return err
+		}
+		return c.Redirect("/admin/google", fiber.StatusSeeOther)
+	})
+	app.Get("/oauth2/callback", func(c *fiber.Ctx) error {
+		if reason := c.Query("error"); reason != "" {
+			return fiber.NewError(fiber.StatusBadRequest, "google authorisation was not granted: "+reason)
+		}
+		if err := store.exchange(c.Context(), c.Query("state"), c.Query("code")); err != nil {
+			return err
+		}
+		if err := cache.Refresh(); err != nil {
+			log.Printf("Connected to Google but unable to reload prices: %v", err)
+		}
+		return c.Redirect("/admin/google", fiber.StatusSeeOther)
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The ranges every quotation reads from
+var priceRanges = []string{"printing_raw", "primary_secondary_addon_raw", "third_addon_raw"}
+
+// priceCacheLoads numbers every load of every price cache, so the indexes
+// built from a load are never mistaken for another's
+var priceCacheLoads atomic.Uint64
+
+// CachedPriceSource keeps every price range in memory so a quotation never waits
+// on the network. Ranges are reloaded together on a fixed interval or on demand,
+// and a range older than the TTL is reloaded before it is served.
+type CachedPriceSource struct {
+	src    PriceSource
+	ranges []string
+	ttl    time.Duration
+
+	// refreshing holds a token while a reload runs against the underlying
+	// source, so reloads are serialised and a request can stop waiting
+	refreshing chan struct{}
+
+	mu         sync.RWMutex
+	tables     map[string][][]interface{}
+	generation uint64
+	loadedAt   time.Time
+	lastErr    error
+}
+
+type PriceCacheStatus struct {
+	Ranges    []string  `json:"ranges"`
+	LoadedAt  time.Time `json:"loadedAt"`
+	TTL       string    `json:"ttl"`
+	Stale     bool      `json:"stale"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+func NewCachedPriceSource(src PriceSource, ranges []string, ttl time.Duration) *CachedPriceSource {
+	return &CachedPriceSource{
+		src:        src,
+		ranges:     ranges,
+		ttl:        ttl,
+		refreshing: make(chan struct{}, 1),
+		tables:     make(map[string][][]interface{}),
+	}
+}
+
+// Refresh reloads all ranges from the underlying source. The cached tables are
+// only replaced once every range has loaded, so a failed refresh keeps serving
+// the previous prices.
+func (c *CachedPriceSource) Refresh() error {
+	return c.reload(context.Background(), true)
+}
+
+// reload waits for any reload already running and then reloads, unless force
+// is false and that reload left the cache fresh. It gives up when ctx is done.
+func (c *CachedPriceSource) reload(ctx context.Context, force bool) error {
+	select {
+	case c.refreshing <- struct{}{}:
+		defer func() { <-c.refreshing }()
+	case <-ctx.Done():
+		return &PricingError{Kind: ErrSourceUnavailable, Range: strings.Join(c.ranges, ", "), Err: ctx.Err()}
+	}
+
+	// another request may have reloaded while we waited
+	if !force && !c.isStale() {
+		return nil
+	}
+	tables, err := fetchPriceTables(ctx, c.src, c.ranges)
+	if err != nil {
+		err = fmt.Errorf("unable to refresh price ranges: %w", err)
+		// a request that gave up says nothing about the source
+		if ctx.Err() == nil {
+			c.mu.Lock()
+			c.lastErr = err
+			c.mu.Unlock()
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.tables = tables.values
+	c.generation = priceCacheLoads.Add(1)
+	c.loadedAt = time.Now()
+	c.lastErr = nil
+	c.mu.Unlock()
+	return nil
+}
+
+// GetRange has no caller context, it is read by catalog reloads that no
+// request waits on
+func (c *CachedPriceSource) GetRange(range_ string) ([][]interface{}, error) {
+	values, ok, stale := c.lookup(range_)
+	if ok && !stale {
+		return values, nil
+	}
+	if err := c.reload(context.Background(), false); err != nil {
+		if ok {
+			log.Printf("Serving stale price range %q: %v", range_, err)
+			return values, nil
+		}
+		return nil, err
+	}
+	values, ok, _ = c.lookup(range_)
+	if !ok {
+		// not one of the preloaded ranges, read it through
+		return c.src.GetRange(range_)
+	}
+	return values, nil
+}
+
+// GetRanges serves the preloaded ranges from memory and fetches any others
+// together
+func (c *CachedPriceSource) GetRanges(ctx context.Context, ranges []string) (map[string][][]interface{}, error) {
+	tables, err := c.fetchTables(ctx, ranges)
+	if err != nil {
+		return nil, err
+	}
+	return tables.values, nil
+}
+
+// fetchTables is GetRanges for fetchPriceTables, with the load each
+// preloaded range came from
+func (c *CachedPriceSource) fetchTables(ctx context.Context, ranges []string) (priceTables, error) {
+	if c.isStale() {
+		if err := c.reload(ctx, false); err != nil {
+			log.Printf("Serving stale price ranges: %v", err)
+		}
+	}
+	tables := newPriceTables(make(map[string][][]interface{}, len(ranges)))
+	var missing []string
+	c.mu.RLock()
+	for _, range_ := range ranges {
+		if values, ok := c.tables[range_]; ok {
+			tables.values[range_] = values
+			tables.generations[range_] = c.generation
+		} else {
+			missing = append(missing, range_)
+		}
+	}
+	c.mu.RUnlock()
+	if len(missing) > 0 {
+		fetched, err := fetchPriceTables(ctx, c.src, missing)
+		if err != nil {
+			return priceTables{}, err
+		}
+		for range_, values := range fetched.values {
+			tables.values[range_] = values
+		}
+	}
+	return tables, nil
+}
+
+func (c *CachedPriceSource) lookup(range_ string) ([][]interface{}, bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	values, ok := c.tables[range_]
+	return values, ok, c.staleLocked()
+}
+
+func (c *CachedPriceSource) isStale() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.staleLocked()
+}
+
+func (c *CachedPriceSource) staleLocked() bool {
+	return c.loadedAt.IsZero() || (c.ttl > 0 && time.Since(c.loadedAt) > c.ttl)
+}
+
+// Start refreshes the cache every interval until stop is closed
+func (c *CachedPriceSource) Start(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Refresh(); err != nil {
+					log.Printf("Background price refresh failed: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *CachedPriceSource) Status() PriceCacheStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status := PriceCacheStatus{
+		Ranges:   c.ranges,
+		LoadedAt: c.loadedAt,
+		TTL:      c.ttl.String(),
+		Stale:    c.staleLocked(),
+	}
+	if c.lastErr != nil {
+		status.LastError = c.lastErr.Error()
+	}
+	return status
+}
+
+// durationFromEnv reads a time.ParseDuration value such as "10m", falling back to def
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return d, nil
+}
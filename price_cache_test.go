@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingPriceSource holds every read until release is closed
+type blockingPriceSource struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingPriceSource) GetRange(range_ string) ([][]interface{}, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return nil, nil
+}
+
+func TestPriceCacheReloadStopsWithTheRequest(t *testing.T) {
+	src := &blockingPriceSource{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(src.release)
+	cache := NewCachedPriceSource(src, []string{printingRange}, time.Hour)
+	go cache.Refresh()
+	<-src.started
+
+	// the cache is stale and another reload holds the source
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := fetchPriceTables(ctx, cache, []string{printingRange})
+	if !errors.Is(err, ErrSourceUnavailable) {
+		t.Errorf("got %v, want the source unavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v for the other reload", elapsed)
+	}
+	if status := cache.Status(); status.LastError != "" {
+		t.Errorf("a request giving up was recorded against the source: %s", status.LastError)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// BatchPriceSource reads several ranges in one round trip. Sources that
+// can't are read one goroutine per range instead.
+type BatchPriceSource interface {
+	GetRanges(ctx context.Context, ranges []string) (map[string][][]interface{}, error)
+}
+
+// priceFetchTimeout bounds how long a quotation waits for its price tables,
+// set from PRICE_FETCH_TIMEOUT
+var priceFetchTimeout = 10 * time.Second
+
+// priceTables are the price ranges a quotation is priced from, fetched up
+// front so the pricing steps never touch the source
+type priceTables struct {
+	values map[string][][]interface{}
+	// generations are the price cache loads ranges were served from. The
+	// index of a range with one is kept until the range is reloaded, the
+	// others are indexed once for these tables.
+	generations map[string]uint64
+	parsed      map[string]interface{}
+}
+
+func newPriceTables(values map[string][][]interface{}) priceTables {
+	return priceTables{values: values, generations: map[string]uint64{}, parsed: map[string]interface{}{}}
+}
+
+// generationalPriceSource serves ranges together with the load they came
+// from, as the price cache does
+type generationalPriceSource interface {
+	fetchTables(ctx context.Context, ranges []string) (priceTables, error)
+}
+
+func (t priceTables) table(range_ string) ([][]interface{}, error) {
+	values, ok := t.values[range_]
+	if !ok {
+		return nil, &PricingError{Kind: ErrRangeMissing, Range: range_}
+	}
+	return values, nil
+}
+
+// fetchPriceTables reads every range at once, giving up when ctx is done or
+// priceFetchTimeout has passed
+func fetchPriceTables(ctx context.Context, src PriceSource, ranges []string) (priceTables, error) {
+	ctx, cancel := context.WithTimeout(ctx, priceFetchTimeout)
+	defer cancel()
+	ranges = uniqueRanges(ranges)
+	if err := ctx.Err(); err != nil {
+		return priceTables{}, &PricingError{Kind: ErrSourceUnavailable, Range: strings.Join(ranges, ", "), Err: err}
+	}
+	if generational, ok := src.(generationalPriceSource); ok {
+		return generational.fetchTables(ctx, ranges)
+	}
+	if batch, ok := src.(BatchPriceSource); ok {
+		tables, err := batch.GetRanges(ctx, ranges)
+		if err != nil {
+			return priceTables{}, err
+		}
+		return newPriceTables(tables), nil
+	}
+
+	type fetched struct {
+		range_ string
+		values [][]interface{}
+		err    error
+	}
+	// buffered, so reads still running after a timeout don't block forever
+	results := make(chan fetched, len(ranges))
+	for _, range_ := range ranges {
+		go func(range_ string) {
+			values, err := src.GetRange(range_)
+			results <- fetched{range_, values, err}
+		}(range_)
+	}
+	tables := newPriceTables(make(map[string][][]interface{}, len(ranges)))
+	for range ranges {
+		select {
+		case result := <-results:
+			if result.err != nil {
+				return priceTables{}, result.err
+			}
+			tables.values[result.range_] = result.values
+		case <-ctx.Done():
+			return priceTables{}, &PricingError{Kind: ErrSourceUnavailable, Range: strings.Join(ranges, ", "), Err: ctx.Err()}
+		}
+	}
+	return tables, nil
+}
+
+func uniqueRanges(ranges []string) []string {
+	unique := make([]string, 0, len(ranges))
+	seen := make(map[string]bool, len(ranges))
+	for _, range_ := range ranges {
+		if !seen[range_] {
+			seen[range_] = true
+			unique = append(unique, range_)
+		}
+	}
+	return unique
+}
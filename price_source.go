@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// PriceSource serves the raw price tables (printing_raw, primary_secondary_addon_raw
+// and third_addon_raw) in the same shape as the Google Sheets values API: one
+// []interface{} per row with trailing empty cells dropped.
+type PriceSource interface {
+	GetRange(range_ string) ([][]interface{}, error)
+}
+
+// SheetsPriceSource reads the price tables straight from the Google Sheet.
+type SheetsPriceSource struct {
+	srv           *sheets.Service
+	spreadsheetId string
+}
+
+func NewSheetsPriceSource(srv *sheets.Service, spreadsheetId string) *SheetsPriceSource {
+	return &SheetsPriceSource{srv: srv, spreadsheetId: spreadsheetId}
+}
+
+func (s *SheetsPriceSource) GetRange(range_ string) ([][]interface{}, error) {
+	resp, err := s.srv.Spreadsheets.Values.Get(s.spreadsheetId, range_).Do()
+	if err != nil {
+		return nil, sheetsError(range_, err)
+	}
+	return resp.Values, nil
+}
+
+// GetRanges reads every range with a single BatchGet call
+func (s *SheetsPriceSource) GetRanges(ctx context.Context, ranges []string) (map[string][][]interface{}, error) {
+	resp, err := s.srv.Spreadsheets.Values.BatchGet(s.spreadsheetId).Ranges(ranges...).Context(ctx).Do()
+	if err != nil {
+		return nil, sheetsError(strings.Join(ranges, ", "), err)
+	}
+	// value ranges come back in the order they were asked for
+	if len(resp.ValueRanges) != len(ranges) {
+		return nil, &PricingError{Kind: ErrSourceUnavailable, Range: strings.Join(ranges, ", "), Err: fmt.Errorf("asked for %d ranges, got %d", len(ranges), len(resp.ValueRanges))}
+	}
+	tables := make(map[string][][]interface{}, len(ranges))
+	for i, valueRange := range resp.ValueRanges {
+		tables[ranges[i]] = valueRange.Values
+	}
+	return tables, nil
+}
+
+func sheetsError(range_ string, err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Message, "Unable to parse range") {
+		return &PricingError{Kind: ErrRangeMissing, Range: range_, Err: err}
+	}
+	return &PricingError{Kind: ErrSourceUnavailable, Range: range_, Err: err}
+}
+
+// FilePriceSource reads exported price tables from a local directory. Each range
+// is stored as <range>.csv, or <range>.json holding an array of rows.
+type FilePriceSource struct {
+	dir string
+}
+
+func NewFilePriceSource(dir string) *FilePriceSource {
+	return &FilePriceSource{dir: dir}
+}
+
+func (s *FilePriceSource) GetRange(range_ string) ([][]interface{}, error) {
+	values, err := s.readCSV(filepath.Join(s.dir, range_+".csv"))
+	if errors.Is(err, fs.ErrNotExist) {
+		values, err = s.readJSON(filepath.Join(s.dir, range_+".json"))
+	}
+	switch {
+	case err == nil:
+		return values, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, &PricingError{Kind: ErrRangeMissing, Range: range_, Err: fmt.Errorf("no %s.csv or %s.json in %s", range_, range_, s.dir)}
+	case isParseError(err):
+		return nil, &PricingError{Kind: ErrMalformedRow, Range: range_, Err: err}
+	default:
+		return nil, &PricingError{Kind: ErrSourceUnavailable, Range: range_, Err: err}
+	}
+}
+
+func (s *FilePriceSource) readCSV(path string) ([][]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]interface{}, 0, len(records))
+	for _, record := range records {
+		row := make([]interface{}, 0, len(record))
+		for _, cell := range record {
+			row = append(row, cell)
+		}
+		values = append(values, trimTrailingEmptyCells(row))
+	}
+	return values, nil
+}
+
+func (s *FilePriceSource) readJSON(path string) ([][]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw [][]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	// Sheets hands back formatted strings, so normalise numbers and bools the same way
+	values := make([][]interface{}, 0, len(raw))
+	for _, rawRow := range raw {
+		row := make([]interface{}, 0, len(rawRow))
+		for _, cell := range rawRow {
+			switch v := cell.(type) {
+			case nil:
+				row = append(row, "")
+			case string:
+				row = append(row, v)
+			case float64:
+				row = append(row, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				row = append(row, fmt.Sprint(v))
+			}
+		}
+		values = append(values, trimTrailingEmptyCells(row))
+	}
+	return values, nil
+}
+
+func isParseError(err error) bool {
+	var csvErr *csv.ParseError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &csvErr) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// The Sheets API omits trailing empty cells, and the pricing methods rely on row
+// length to tell the tables apart, so file sources must do the same.
+func trimTrailingEmptyCells(row []interface{}) []interface{} {
+	for len(row) > 0 && row[len(row)-1] == "" {
+		row = row[:len(row)-1]
+	}
+	return row
+}
+
+// MemoryPriceSource holds price tables in memory, for running offline and in tests.
+type MemoryPriceSource struct {
+	mu     sync.RWMutex
+	tables map[string][][]interface{}
+}
+
+func NewMemoryPriceSource(tables map[string][][]interface{}) *MemoryPriceSource {
+	if tables == nil {
+		tables = make(map[string][][]interface{})
+	}
+	return &MemoryPriceSource{tables: tables}
+}
+
+func (s *MemoryPriceSource) GetRange(range_ string) ([][]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values, ok := s.tables[range_]
+	if !ok {
+		return nil, &PricingError{Kind: ErrRangeMissing, Range: range_}
+	}
+	return values, nil
+}
+
+func (s *MemoryPriceSource) SetRange(range_ string, values [][]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[range_] = values
+}
+
+// newPriceSourceFromEnv also returns the OAuth token store when the sheet is
+// read with installed-app OAuth, nil otherwise
+func newPriceSourceFromEnv() (PriceSource, *oauthTokenStore, error) {
+	switch os.Getenv("PRICE_SOURCE") {
+	case "", "sheets":
+		srv, store, err := connectToGoogleSheet()
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewSheetsPriceSource(srv, os.Getenv("SPREADSHEET_ID")), store, nil
+	case "file":
+		dir := os.Getenv("PRICE_SOURCE_DIR")
+		if dir == "" {
+			dir = "prices"
+		}
+		return NewFilePriceSource(dir), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown PRICE_SOURCE %q, expected sheets or file", os.Getenv("PRICE_SOURCE"))
+	}
+}
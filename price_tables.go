@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// tableLayout is the standard column order of a kind of price range. A range
+// with a header row may order its columns any way it likes; one without is
+// read in this order.
+type tableLayout struct {
+	name    string
+	columns []tableColumn
+}
+
+type tableColumn struct {
+	name     string
+	aliases  []string
+	required bool
+}
+
+var printingLayout = tableLayout{"printing", []tableColumn{
+	{"id", nil, false},
+	{"colours", []string{"colour", "noofcolours", "noofcolour"}, true},
+	{"material", nil, true},
+	{"size", []string{"sizecategory"}, true},
+	{"quantity", []string{"qty"}, true},
+	{"price", nil, true},
+}}
+
+// Positions in the printing layout
+const (
+	printingColoursColumn  = 1
+	printingMaterialColumn = 2
+	printingSizeColumn     = 3
+	printingQuantityColumn = 4
+	printingPriceColumn    = 5
+)
+
+// add-on price columns are numbered in this layout, see AddOnType
+var addOnLayout = tableLayout{"add-on", []tableColumn{
+	{"addon", []string{"finishing", "name", "label"}, true},
+	{"spec", []string{"option", "size"}, true},
+	{"quantity", []string{"qty"}, true},
+	{"single", []string{"singleside", "singlesideprice"}, true},
+	{"double", []string{"doubleside", "doublesideprice"}, false},
+}}
+
+// headerSearchRows is how far down a range its header row may be
+const headerSearchRows = 10
+
+// columnMap is where each layout column is in a range, -1 when it isn't
+type columnMap struct {
+	layout    tableLayout
+	columns   []int
+	hasHeader bool
+	// header is the index of the header row, data rows come after it
+	header int
+}
+
+// mapColumns finds the header row of a range, the first row with a quantity
+// column, and maps the layout onto it. A range without one is read in the
+// standard layout.
+func mapColumns(range_ string, values [][]interface{}, layout tableLayout) (columnMap, error) {
+	m := columnMap{layout: layout, columns: make([]int, len(layout.columns)), header: -1}
+	for i := range m.columns {
+		m.columns[i] = i
+	}
+	for i, row := range values {
+		if i >= headerSearchRows {
+			break
+		}
+		names := make(map[string]int, len(row))
+		for j, cell := range row {
+			name := headerName(cell)
+			if _, ok := names[name]; !ok && name != "" {
+				names[name] = j
+			}
+		}
+		if _, ok := names["quantity"]; !ok {
+			if _, ok := names["qty"]; !ok {
+				continue
+			}
+		}
+		m.hasHeader, m.header = true, i
+		for k, column := range layout.columns {
+			m.columns[k] = -1
+			for _, name := range append([]string{column.name}, column.aliases...) {
+				if j, ok := names[name]; ok {
+					m.columns[k] = j
+					break
+				}
+			}
+			if m.columns[k] < 0 && column.required {
+				return m, newPricingError(ErrMalformedRow, range_, i+1, "%s header has no %s column", layout.name, column.name)
+			}
+		}
+		break
+	}
+	return m, nil
+}
+
+// headerName lower cases a header cell and drops everything but letters and
+// digits, so "No. of Colours" reads as "noofcolours"
+func headerName(cell interface{}) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, fmt.Sprint(cell))
+}
+
+// column returns where the layout column at position is in the range. Without
+// a header, positions past the layout are read as they are.
+func (m columnMap) column(position int) (int, error) {
+	if position < len(m.columns) {
+		if m.columns[position] >= 0 {
+			return m.columns[position], nil
+		}
+		return -1, fmt.Errorf("no %s column", m.layout.columns[position].name)
+	}
+	if !m.hasHeader {
+		return position, nil
+	}
+	return -1, fmt.Errorf("no column %d in the %s layout", position+1, m.layout.name)
+}
+
+// rows calls fn with every data row that has a whole number quantity and
+// all the key columns, as their trimmed text
+func (m columnMap) rows(values [][]interface{}, quantityPosition int, keys []int, fn func(index int, quantity int, key []string, row []interface{})) {
+	quantityColumn := m.columns[quantityPosition]
+	key := make([]string, len(keys))
+	for i := m.header + 1; i < len(values); i++ {
+		row := values[i]
+		if quantityColumn >= len(row) {
+			continue
+		}
+		quantity, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(row[quantityColumn])))
+		if err != nil {
+			continue
+		}
+		complete := true
+		for k, position := range keys {
+			column := m.columns[position]
+			if column >= len(row) {
+				complete = false
+				break
+			}
+			key[k] = strings.TrimSpace(fmt.Sprint(row[column]))
+		}
+		if complete {
+			fn(i, quantity, key, row)
+		}
+	}
+}
+
+type printingKey struct {
+	colours  string
+	material string
+	size     string
+}
+
+// printingTable is a printing range indexed by colours, material and size,
+// each holding its rows by quantity
+type printingTable struct {
+	range_  string
+	columns columnMap
+	index   map[printingKey]quantityTable
+}
+
+func newPrintingTable(range_ string, values [][]interface{}) (*printingTable, error) {
+	columns, err := mapColumns(range_, values, printingLayout)
+	if err != nil {
+		return nil, err
+	}
+	t := &printingTable{range_: range_, columns: columns, index: make(map[printingKey]quantityTable)}
+	keys := []int{printingColoursColumn, printingMaterialColumn, printingSizeColumn}
+	columns.rows(values, printingQuantityColumn, keys, func(index int, quantity int, key []string, row []interface{}) {
+		k := printingKey{key[0], key[1], key[2]}
+		t.index[k] = t.index[k].add(range_, quantityRow{quantity: quantity, index: index, row: row})
+	})
+	return t, nil
+}
+
+// lookup returns the rows pricing colours, material and the first of sizes
+// with any, so a size without its own is priced as its parent, and their column
+func (t *printingTable) lookup(colours string, material string, sizes []string) (quantityTable, int) {
+	for _, size := range sizes {
+		if table, ok := t.index[printingKey{colours, material, size}]; ok {
+			return table, t.columns.columns[printingPriceColumn]
+		}
+	}
+	return quantityTable{range_: t.range_}, t.columns.columns[printingPriceColumn]
+}
+
+type addOnKey struct {
+	addOn string
+	spec  string
+}
+
+// addOnTable is an add-on range indexed by add-on and spec (an option or
+// size category), each holding its rows by quantity
+type addOnTable struct {
+	range_  string
+	columns columnMap
+	index   map[addOnKey]quantityTable
+}
+
+func newAddOnTable(range_ string, values [][]interface{}) (*addOnTable, error) {
+	columns, err := mapColumns(range_, values, addOnLayout)
+	if err != nil {
+		return nil, err
+	}
+	t := &addOnTable{range_: range_, columns: columns, index: make(map[addOnKey]quantityTable)}
+	columns.rows(values, addOnQuantityColumn, []int{0, 1}, func(index int, quantity int, key []string, row []interface{}) {
+		k := addOnKey{key[0], key[1]}
+		t.index[k] = t.index[k].add(range_, quantityRow{quantity: quantity, index: index, row: row})
+	})
+	return t, nil
+}
+
+// lookup returns the rows pricing the first of an add-on's specs with any, e.g.
+// a size and then its parents, and where the price column at position is
+func (t *addOnTable) lookup(addOn string, specs []string, position int) (quantityTable, int, error) {
+	column, err := t.columns.column(position)
+	if err != nil {
+		return quantityTable{}, -1, newPricingError(ErrMalformedRow, t.range_, 0, "%s price of %s: %v", addOn, strings.Join(specs, "/"), err)
+	}
+	for _, spec := range specs {
+		if table, ok := t.index[addOnKey{addOn, spec}]; ok {
+			return table, column, nil
+		}
+	}
+	return quantityTable{range_: t.range_}, column, nil
+}
+
+// parsedTables keeps the last index built for each cached range, reused until
+// the price cache loads the range again
+var parsedTables = struct {
+	sync.Mutex
+	entries map[string]parsedTable
+}{entries: make(map[string]parsedTable)}
+
+type parsedTable struct {
+	generation uint64
+	table      interface{}
+}
+
+// index returns range_ indexed by parse, building it at most once per cache
+// load, or once for these tables when the range didn't come from the cache
+func (t priceTables) index(range_ string, layout tableLayout, parse func([][]interface{}) (interface{}, error)) (interface{}, error) {
+	values, err := t.table(range_)
+	if err != nil {
+		return nil, err
+	}
+	key := layout.name + "\x00" + range_
+	if table, ok := t.parsed[key]; ok {
+		return table, nil
+	}
+	generation, cached := t.generations[range_]
+	if cached {
+		parsedTables.Lock()
+		entry, ok := parsedTables.entries[key]
+		parsedTables.Unlock()
+		if ok && entry.generation == generation {
+			t.parsed[key] = entry.table
+			return entry.table, nil
+		}
+	}
+	table, err := parse(values)
+	if err != nil {
+		return nil, err
+	}
+	t.parsed[key] = table
+	if cached {
+		parsedTables.Lock()
+		parsedTables.entries[key] = parsedTable{generation, table}
+		parsedTables.Unlock()
+	}
+	return table, nil
+}
+
+// printing returns range_ as a printing table
+func (t priceTables) printing(range_ string) (*printingTable, error) {
+	table, err := t.index(range_, printingLayout, func(values [][]interface{}) (interface{}, error) {
+		return newPrintingTable(range_, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return table.(*printingTable), nil
+}
+
+// addOns returns range_ as an add-on table
+func (t priceTables) addOns(range_ string) (*addOnTable, error) {
+	table, err := t.index(range_, addOnLayout, func(values [][]interface{}) (interface{}, error) {
+		return newAddOnTable(range_, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return table.(*addOnTable), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrintingTableColumns(t *testing.T) {
+	row := []interface{}{"1", "4colours", "art card 350gsm", "A4", "100", "100.00"}
+	tests := []struct {
+		name    string
+		values  [][]interface{}
+		wantRow int
+	}{
+		{"standard layout", [][]interface{}{row}, 1},
+		{"header", [][]interface{}{
+			{"ID", "No. of Colours", "Material", "Size", "Qty", "Price"},
+			row,
+		}, 2},
+		{"columns reordered under a title", [][]interface{}{
+			{"printing prices"},
+			{"Price", "Quantity", "Size Category", "Material", "Colours"},
+			{"100.00", "100", "A4", "art card 350gsm", "4colours"},
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := newPrintingTable("printing_raw", tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A4+ has no rows of its own, so it is priced as its parent
+			rows, column := table.lookup("4colours", "art card 350gsm", []string{"A4+", "A4"})
+			quoted, err := rows.priceAt(100, column)
+			if err != nil || quoted.NotAvailable || quoted.Price != 10000 || quoted.Source.Row != tt.wantRow {
+				t.Errorf("got %+v, %v", quoted, err)
+			}
+		})
+	}
+}
+
+func TestPriceTableHeaderErrors(t *testing.T) {
+	_, err := newPrintingTable("printing_raw", [][]interface{}{{"colours", "material", "quantity", "price"}})
+	if !errors.Is(err, ErrMalformedRow) {
+		t.Errorf("a printing header without size: got %v", err)
+	}
+	// the double side price column is optional, until an add-on asks for it
+	table, err := newAddOnTable("primary_secondary_addon_raw", [][]interface{}{
+		{"addon", "spec", "quantity", "single"},
+		{"matt lam 1side", "A4", "100", "20.00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := table.lookup("matt lam 1side", []string{"A4"}, 4); !errors.Is(err, ErrMalformedRow) {
+		t.Errorf("a missing double side column: got %v", err)
+	}
+}
+
+func TestPriceTableIndexReuse(t *testing.T) {
+	cache := NewCachedPriceSource(testPrices(), []string{printingRange}, time.Hour)
+	printing := func(src PriceSource) *printingTable {
+		t.Helper()
+		tables, err := fetchPriceTables(context.Background(), src, []string{printingRange})
+		if err != nil {
+			t.Fatal(err)
+		}
+		table, err := tables.printing(printingRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return table
+	}
+	first := printing(cache)
+	if printing(cache) != first {
+		t.Error("the cached range was indexed again")
+	}
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if printing(cache) == first {
+		t.Error("a reloaded range kept the old index")
+	}
+	// a range read straight from its source is indexed for those tables alone
+	if printing(testPrices()) == printing(testPrices()) {
+		t.Error("an uncached range shared its index")
+	}
+}
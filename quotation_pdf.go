@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CompanyDetails is printed at the top of every PDF quotation
+type CompanyDetails struct {
+	Name         string
+	Address      string
+	Phone        string
+	Email        string
+	ValidityDays int
+	Terms        []string
+	// QuantityTerm adds the term on quantities above those quoted, which
+	// depends on the quotation
+	QuantityTerm bool
+}
+
+func quantityTerm(maxQuantity int) string {
+	return fmt.Sprintf("Paper prices fluctuate, so quantities above %dpcs are quoted separately on request.", maxQuantity)
+}
+
+func companyDetailsFromEnv() CompanyDetails {
+	validityDays, err := strconv.Atoi(getEnvOrDefault("QUOTE_VALIDITY_DAYS", "14"))
+	if err != nil || validityDays <= 0 {
+		validityDays = 14
+	}
+	terms := []string{
+		"All prices are in Malaysian Ringgit (RM) and are estimates for the specification above. The final price is confirmed after artwork review.",
+		"Print process 6-7 days (art card) / 8-10 days (with beautify finishing) / 13-14 days (carton material), excluding Saturday, Sunday, public holidays and pre-preparation works.",
+	}
+	quantityTerm := true
+	if custom := os.Getenv("QUOTE_TERMS"); custom != "" {
+		terms, quantityTerm = strings.Split(custom, "|"), false
+	}
+	return CompanyDetails{
+		Name:         getEnvOrDefault("COMPANY_NAME", "Cetak Copilot"),
+		Address:      os.Getenv("COMPANY_ADDRESS"),
+		Phone:        getEnvOrDefault("COMPANY_PHONE", "+60163443238"),
+		Email:        os.Getenv("COMPANY_EMAIL"),
+		ValidityDays: validityDays,
+		Terms:        terms,
+		QuantityTerm: quantityTerm,
+	}
+}
+
+const (
+	pdfMargin   = 50.0
+	pdfBodySize = 10.0
+	pdfLeading  = 14.0
+)
+
+// quotationPDF keeps track of the write position while laying out a quotation
+type quotationPDF struct {
+	doc  *pdfDocument
+	page int
+	y    float64
+}
+
+// need starts a new page when fewer than height points are left above the footer
+func (p *quotationPDF) need(height float64) {
+	if p.y-height < pdfMargin+20 {
+		p.page = p.doc.addPage()
+		p.y = pdfPageHeight - pdfMargin
+	}
+}
+
+func (p *quotationPDF) paragraph(s string, x, width, size float64, bold bool) {
+	for _, line := range pdfWrap(s, width, size, bold) {
+		p.need(pdfLeading)
+		p.doc.text(p.page, x, p.y, size, bold, line)
+		p.y -= pdfLeading
+	}
+}
+
+func (p *quotationPDF) field(label, value string) {
+	p.need(pdfLeading)
+	p.doc.text(p.page, pdfMargin, p.y, pdfBodySize, true, label)
+	lines := pdfWrap(value, pdfPageWidth-2*pdfMargin-110, pdfBodySize, false)
+	for i, line := range lines {
+		if i > 0 {
+			p.need(pdfLeading)
+		}
+		p.doc.text(p.page, pdfMargin+110, p.y, pdfBodySize, false, line)
+		p.y -= pdfLeading
+	}
+}
+
+func (p *quotationPDF) heading(s string) {
+	p.y -= 8
+	p.need(pdfLeading * 3)
+	p.doc.text(p.page, pdfMargin, p.y, 12, true, s)
+	p.y -= 6
+	p.doc.line(p.page, pdfMargin, p.y, pdfPageWidth-pdfMargin, p.y, 0.5)
+	p.y -= pdfLeading
+}
+
+// renderQuotationPDF lays out a stored quotation as a branded A4 document
+func renderQuotationPDF(record *QuotationRecord, company CompanyDetails) []byte {
+	q := record.Quotation
+	header := record.Header
+	p := &quotationPDF{doc: newPDFDocument()}
+	p.page = p.doc.addPage()
+	right := pdfPageWidth - pdfMargin
+
+	// Letterhead
+	p.doc.fillRect(p.page, 0, pdfPageHeight-90, pdfPageWidth, 90, 0.11, 0.28, 0.53)
+	p.doc.setTextColor(p.page, 1, 1, 1)
+	p.doc.text(p.page, pdfMargin, pdfPageHeight-50, 20, true, company.Name)
+	contactY := pdfPageHeight - 35.0
+	for _, contact := range []string{company.Address, company.Phone, company.Email} {
+		if contact != "" {
+			p.doc.textRight(p.page, right, contactY, 9, false, contact)
+			contactY -= 12
+		}
+	}
+	p.doc.setTextColor(p.page, 0, 0, 0)
+	p.y = pdfPageHeight - 125
+
+	p.doc.text(p.page, pdfMargin, p.y, 16, true, "QUOTATION : BOX PRINTING")
+	p.y -= 24
+	validUntil := record.CreatedAt.AddDate(0, 0, company.ValidityDays)
+	p.field("Quote No.", record.Number)
+	p.field("Date", record.CreatedAt.Format("2 January 2006"))
+	p.field("Valid Until", validUntil.Format("2 January 2006"))
+	if q.Customer != "" {
+		p.field("Customer", q.Customer)
+	}
+
+	p.heading("Specification")
+	p.field("Product", "box")
+	p.field("Size", header.SizeCategory)
+	if header.Blank != "" {
+		p.field("Blank Size", header.Blank)
+	}
+	p.field("Shape", header.Shape)
+	p.field("Machine", header.Machine)
+	p.field("Print Side", header.PrintSide)
+	p.field("Colour", header.Colour)
+	p.field("Finishing", "die cut / die cut + gluing")
+	p.field("Material", header.Summary)
+
+	p.heading("Estimated Price")
+	for _, pricing := range record.Pricing {
+		p.need(pdfLeading * float64(len(pricing.LineItems)+3))
+		p.doc.text(p.page, pdfMargin, p.y, 11, true, fmt.Sprintf("%d pcs", pricing.Quantity))
+		p.y -= pdfLeading
+		for _, item := range pricing.LineItems {
+			label := item.Label
+			if item.Option != "" {
+				label += " " + item.Option
+			}
+			p.doc.text(p.page, pdfMargin+12, p.y, pdfBodySize, false, label)
+			p.doc.textRight(p.page, right, p.y, pdfBodySize, false, priceText(item))
+			p.y -= pdfLeading
+		}
+		p.doc.line(p.page, right-150, p.y+10, right, p.y+10, 0.5)
+		total := "Total"
+		if pricing.Estimated {
+			total = "Total (estimate)"
+		}
+		if !pricing.Available {
+			p.doc.text(p.page, pdfMargin+12, p.y, pdfBodySize, true, "Not quoted")
+			p.y -= pdfLeading
+			p.paragraph(pricing.reason(), pdfMargin+12, right-pdfMargin-12, pdfBodySize, false)
+			p.y -= pdfLeading * 0.5
+			continue
+		}
+		p.doc.text(p.page, pdfMargin+12, p.y, pdfBodySize, true, total)
+		p.doc.textRight(p.page, right, p.y, pdfBodySize, true, totalText(pricing))
+		p.y -= pdfLeading * 1.5
+	}
+
+	p.heading("Terms")
+	terms := company.Terms
+	if company.QuantityTerm {
+		terms = append(terms[:len(terms):len(terms)], quantityTerm(header.MaxQuantity))
+	}
+	for i, term := range terms {
+		p.paragraph(fmt.Sprintf("%d. %s", i+1, term), pdfMargin, pdfPageWidth-2*pdfMargin, 9, false)
+	}
+	p.paragraph(fmt.Sprintf("This quotation is valid until %s.", validUntil.Format("2 January 2006")), pdfMargin, pdfPageWidth-2*pdfMargin, 9, false)
+
+	for i := range p.doc.pages {
+		footer := fmt.Sprintf("%s  |  Page %d of %d", record.Number, i+1, len(p.doc.pages))
+		p.doc.textRight(i, right, pdfMargin-20, 8, false, footer)
+	}
+	return p.doc.bytes()
+}
+
+// quotationPDFAccess lets the customer's link in on the record's token and
+// anyone else only with the admin login. A wrong token reads as not found, so
+// the quote numbers can't be probed.
+func quotationPDFAccess(store *QuotationStore) fiber.Handler {
+	admin := adminMiddleware()
+	return func(c *fiber.Ctx) error {
+		token := c.Query("token")
+		if token == "" {
+			return admin(c)
+		}
+		record, err := store.Get(c.Params("number"))
+		if err != nil && !errors.Is(err, ErrQuotationNotFound) {
+			return err
+		}
+		if err != nil || record.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(record.Token)) != 1 {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("quotation %s not found", c.Params("number")))
+		}
+		return c.Next()
+	}
+}
+
+func registerQuotationPDFRoutes(app *fiber.App, store *QuotationStore) {
+	app.Get("/quotations/:number.pdf", quotationPDFAccess(store), func(c *fiber.Ctx) error {
+		record, err := store.Get(c.Params("number"))
+		if errors.Is(err, ErrQuotationNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("quotation %s not found", c.Params("number")))
+		}
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", record.Number+".pdf"))
+		c.Set(fiber.HeaderLastModified, record.CreatedAt.UTC().Format(http.TimeFormat))
+		return c.Send(renderQuotationPDF(record, companyDetailsFromEnv()))
+	})
+}
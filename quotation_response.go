@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const quotationAPIVersion = "v1"
+
+// QuotationResponse is the machine readable form of a quotation: the request,
+// the header fields and one Pricing per quantity with its line items and total.
+// Text carries the message in the requested format, WhatsApp unless ?format=
+// asks for another.
+type QuotationResponse struct {
+	Version   string          `json:"version"`
+	Number    string          `json:"number"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Quotation *Quotation      `json:"quotation"`
+	Header    QuotationHeader `json:"header"`
+	Pricing   []*Pricing      `json:"pricing"`
+	// UnavailableQuantities can't be quoted, their pricing says why
+	UnavailableQuantities []int  `json:"unavailableQuantities"`
+	Format                string `json:"format"`
+	Text                  string `json:"text"`
+	// PDF is the customer's link to the quotation as a PDF
+	PDF string `json:"pdf"`
+	// Imposition is for production, only sent when ?imposition=true asks
+	Imposition []Imposition `json:"imposition,omitempty"`
+}
+
+func newQuotationResponse(record *QuotationRecord) *QuotationResponse {
+	return &QuotationResponse{
+		Version:   quotationAPIVersion,
+		Number:    record.Number,
+		CreatedAt: record.CreatedAt,
+		Quotation: record.Quotation,
+		Header:    record.Header,
+		Pricing:   record.Pricing,
+		// never null, so clients can test its length
+		UnavailableQuantities: unavailableQuantities(record.Pricing),
+		Format:                defaultRendererFormat,
+		Text:                  record.Text,
+		PDF:                   record.pdfPath(),
+	}
+}
+
+func unavailableQuantities(pricings []*Pricing) []int {
+	quantities := []int{}
+	for _, pricing := range pricings {
+		if !pricing.Available {
+			quantities = append(quantities, pricing.Quantity)
+		}
+	}
+	return quantities
+}
+
+func registerQuotationAPIRoutes(api fiber.Router, src PriceSource, store *QuotationStore) {
+	api.Post("/quotations", func(c *fiber.Ctx) error {
+		quotation := new(Quotation)
+		if err := c.BodyParser(quotation); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		// one catalog for the whole request, however often it reloads
+		catalog := productCatalog.Current()
+		quotation.normaliseAddOns(catalog)
+		if errs := quotation.validate(catalog); errs != nil {
+			return validationErrorResponse(c, errs)
+		}
+		// The response itself is JSON, so only an explicit ?format= changes the text
+		format := c.Query("format", defaultRendererFormat)
+		renderer, err := rendererFor(format)
+		if err != nil {
+			return err
+		}
+		pricings, err := quotation.calculateQuotation(c.UserContext(), src, catalog)
+		if err != nil {
+			return err
+		}
+		record, err := quotation.newQuotationRecord(catalog, pricings)
+		if err != nil {
+			return err
+		}
+		if err := store.Save(record); err != nil {
+			return err
+		}
+		response := newQuotationResponse(record)
+		if format != defaultRendererFormat {
+			if response.Text, err = renderer.Render(record); err != nil {
+				return err
+			}
+			response.Format = format
+		}
+		if c.QueryBool("imposition") {
+			response.Imposition = quotation.impositions(catalog)
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+}
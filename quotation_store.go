@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	quotationsBucket = []byte("quotations")
+	sequencesBucket  = []byte("sequences")
+
+	ErrQuotationNotFound = errors.New("quotation not found")
+)
+
+// QuotationRecord is a generated quotation as it was sent to the customer
+type QuotationRecord struct {
+	Number    string     `json:"number"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Quotation *Quotation `json:"quotation"`
+	Pricing   []*Pricing `json:"pricing"`
+	Text      string     `json:"text"`
+	// Token is the secret in the customer's PDF link, so the link can't be
+	// guessed from the quote number. Records saved before it have none.
+	Token string `json:"token,omitempty"`
+	// CatalogVersion is the catalog the quotation was checked and priced
+	// with, its add-ons already spelt that catalog's way. Records saved
+	// before it have none.
+	CatalogVersion string `json:"catalogVersion,omitempty"`
+	// Header is the quotation's header as it was quoted, which the PDF and
+	// every renderer show rather than working it out again
+	Header QuotationHeader `json:"header"`
+}
+
+// pdfPath is the customer's link to the PDF quotation
+func (r *QuotationRecord) pdfPath() string {
+	return "/quotations/" + r.Number + ".pdf?token=" + r.Token
+}
+
+// QuotationFilter picks the quotations to list. Before is the number of the
+// last quotation on the previous page, so a page carries on where it stopped.
+type QuotationFilter struct {
+	From     time.Time
+	To       time.Time
+	Customer string
+	Before   string
+	Limit    int
+}
+
+const (
+	defaultQuotationListLimit = 100
+	maxQuotationListLimit     = 500
+)
+
+// QuotationStore keeps every generated quotation in a bbolt file, keyed by its
+// quote number. Numbers run CQ-<year>-000001 upwards and restart every year.
+type QuotationStore struct {
+	db *bolt.DB
+}
+
+func OpenQuotationStore(path string) (*QuotationStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open quotation store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(quotationsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(sequencesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &QuotationStore{db: db}, nil
+}
+
+func (s *QuotationStore) Close() error {
+	return s.db.Close()
+}
+
+// Save numbers the record and stores it. bbolt runs one write transaction at a
+// time, so concurrent saves never share a number.
+func (s *QuotationStore) Save(record *QuotationRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	if record.Token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		record.Token = hex.EncodeToString(b)
+	}
+	year := strconv.Itoa(record.CreatedAt.Year())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		sequences, err := tx.Bucket(sequencesBucket).CreateBucketIfNotExists([]byte(year))
+		if err != nil {
+			return err
+		}
+		seq, err := sequences.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.Number = fmt.Sprintf("CQ-%s-%06d", year, seq)
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(quotationsBucket).Put([]byte(record.Number), b)
+	})
+}
+
+func (s *QuotationStore) Get(number string) (*QuotationRecord, error) {
+	var record *QuotationRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(quotationsBucket).Get([]byte(number))
+		if b == nil {
+			return ErrQuotationNotFound
+		}
+		var err error
+		record, err = decodeQuotationRecord(b)
+		return err
+	})
+	return record, err
+}
+
+// decodeQuotationRecord reads a stored record as it was saved, whatever the
+// catalog is now. Records saved before add-ons were a list carry the old
+// add-on groups, which are moved into the list.
+func decodeQuotationRecord(b []byte) (*QuotationRecord, error) {
+	record := new(QuotationRecord)
+	if err := json.Unmarshal(b, record); err != nil {
+		return nil, err
+	}
+	if record.Quotation != nil {
+		record.Quotation.moveLegacyAddOns()
+	}
+	return record, nil
+}
+
+// addMissingHeaders gives records saved before headers were kept the header
+// catalog works out for them, once, so from then on they render the same
+// whatever the catalog becomes
+func (s *QuotationStore) addMissingHeaders(catalog *Catalog) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(quotationsBucket)
+		updated := map[string][]byte{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			record, err := decodeQuotationRecord(v)
+			if err != nil {
+				return fmt.Errorf("quotation %s: %w", k, err)
+			}
+			if record.Header != (QuotationHeader{}) || record.Quotation == nil || len(record.Quotation.Quantity) == 0 {
+				return nil
+			}
+			record.Header = record.Quotation.getHeader(catalog)
+			b, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			updated[string(k)] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// bbolt doesn't allow writes while ForEach walks the bucket
+		for k, b := range updated {
+			if err := bucket.Put([]byte(k), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// quotationListing is the part of a stored record List filters on, so records
+// are only decoded in full once they are on the page
+type quotationListing struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Quotation *struct {
+		Customer string `json:"customer"`
+	} `json:"quotation"`
+}
+
+// List returns a page of the quotations matching filter, newest first, and
+// the number to pass as Before for the next page, "" on the last page.
+// Numbers run in the order quotations were saved, so the walk stops at the
+// first quotation older than From.
+func (s *QuotationStore) List(filter QuotationFilter) ([]*QuotationRecord, string, error) {
+	records := []*QuotationRecord{}
+	next := ""
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultQuotationListLimit
+	}
+	if limit > maxQuotationListLimit {
+		limit = maxQuotationListLimit
+	}
+	customer := strings.ToLower(filter.Customer)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(quotationsBucket).Cursor()
+		k, v := c.Last()
+		if filter.Before != "" {
+			if k, v = c.Seek([]byte(filter.Before)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil; k, v = c.Prev() {
+			var listing quotationListing
+			if err := json.Unmarshal(v, &listing); err != nil {
+				return fmt.Errorf("quotation %s: %w", k, err)
+			}
+			if !filter.From.IsZero() && listing.CreatedAt.Before(filter.From) {
+				break
+			}
+			if !filter.To.IsZero() && !listing.CreatedAt.Before(filter.To) {
+				continue
+			}
+			if customer != "" && (listing.Quotation == nil || !strings.Contains(strings.ToLower(listing.Quotation.Customer), customer)) {
+				continue
+			}
+			if len(records) == limit {
+				next = records[len(records)-1].Number
+				break
+			}
+			record, err := decodeQuotationRecord(v)
+			if err != nil {
+				return fmt.Errorf("quotation %s: %w", k, err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, next, err
+}
+
+// newQuotationRecord keeps the WhatsApp text with the record, since that is
+// the message the customer was sent
+func (q *Quotation) newQuotationRecord(catalog *Catalog, pricings []*Pricing) (*QuotationRecord, error) {
+	record := &QuotationRecord{Quotation: q, Pricing: pricings, CatalogVersion: catalog.version, Header: q.getHeader(catalog)}
+	text, err := whatsappRenderer{}.Render(record)
+	if err != nil {
+		return nil, err
+	}
+	record.Text = text
+	return record, nil
+}
+
+// parseQuotationFilter reads ?from=2026-01-01&to=2026-01-31&customer=acme&limit=50&before=CQ-2026-000123,
+// to being inclusive
+func parseQuotationFilter(c *fiber.Ctx) (QuotationFilter, error) {
+	filter := QuotationFilter{Customer: c.Query("customer"), Before: c.Query("before"), Limit: c.QueryInt("limit", defaultQuotationListLimit)}
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "from must be a date like 2026-01-31")
+		}
+		filter.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "to must be a date like 2026-01-31")
+		}
+		filter.To = t.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func registerQuotationStoreRoutes(api fiber.Router, store *QuotationStore) {
+	api.Get("/quotations", adminMiddleware(), func(c *fiber.Ctx) error {
+		filter, err := parseQuotationFilter(c)
+		if err != nil {
+			return err
+		}
+		records, next, err := store.List(filter)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"quotations": records, "next": next})
+	})
+	api.Get("/quotations/:number", adminMiddleware(), func(c *fiber.Ctx) error {
+		record, err := store.Get(c.Params("number"))
+		if errors.Is(err, ErrQuotationNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("quotation %s not found", c.Params("number")))
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(record)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func testQuotationStore(t *testing.T) *QuotationStore {
+	t.Helper()
+	store, err := OpenQuotationStore(filepath.Join(t.TempDir(), "quotations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestQuotationRecordKeepsItsCatalog(t *testing.T) {
+	catalog := testCatalog(t)
+	catalog.version = "v1"
+	q := testQuotation(SelectedAddOn{"surfaceProtectionPrinting", "matt lam 1side"})
+	record, err := q.newQuotationRecord(catalog, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := testQuotationStore(t)
+	if err := store.Save(record); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(record.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CatalogVersion != "v1" || !reflect.DeepEqual(got.Quotation.AddOns, q.AddOns) {
+		t.Errorf("got version %q add-ons %v, want v1 %v", got.CatalogVersion, got.Quotation.AddOns, q.AddOns)
+	}
+	// the header is rendered as it was quoted
+	got.Header.Summary = "as quoted"
+	text, err := plainTextRenderer{}.Render(got)
+	if err != nil || !strings.Contains(text, "as quoted") {
+		t.Errorf("got %q, %v", text, err)
+	}
+	if !reflect.DeepEqual(record.Header, q.getHeader(catalog)) {
+		t.Errorf("header %+v, want %+v", record.Header, q.getHeader(catalog))
+	}
+}
+
+func TestAddMissingHeaders(t *testing.T) {
+	store := testQuotationStore(t)
+	legacy := `{"number":"CQ-2025-000001","quotation":{"sizeCategory":"A4","material":"art card 350gsm","noOfColours":"4colours","quantity":[100,200]}}`
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(quotationsBucket).Put([]byte("CQ-2025-000001"), []byte(legacy))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := testCatalog(t)
+	if err := store.addMissingHeaders(catalog); err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.Get("CQ-2025-000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testQuotation().getHeader(catalog); !reflect.DeepEqual(record.Header, want) {
+		t.Errorf("header %+v, want %+v", record.Header, want)
+	}
+}
+
+func TestDecodeLegacyQuotationRecord(t *testing.T) {
+	record, err := decodeQuotationRecord([]byte(`{"number":"CQ-2025-000001","quotation":{"sizeCategory":"A4","primaryAddOns":{"surfaceProtectionPrinting":"Matt Lam 1side"},"thirdAddOns":{"isDoubleSide":true}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the groups move into the list, with the options as they were quoted
+	want := []SelectedAddOn{{"surfaceProtectionPrinting", "Matt Lam 1side"}}
+	if q := record.Quotation; !reflect.DeepEqual(q.AddOns, want) || !q.IsDoubleSide || q.PrimaryAddOns != nil || q.ThirdAddOns != nil {
+		t.Errorf("got %+v", q)
+	}
+}
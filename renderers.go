@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// QuoteRenderer turns a computed quotation into text for one channel. It
+// reads the record alone, so a stored quotation renders as it was quoted.
+type QuoteRenderer interface {
+	ContentType() string
+	Render(record *QuotationRecord) (string, error)
+}
+
+const defaultRendererFormat = "whatsapp"
+
+var quoteRenderers = map[string]QuoteRenderer{
+	"whatsapp": whatsappRenderer{},
+	"plain":    plainTextRenderer{},
+	"html":     htmlEmailRenderer{},
+	"markdown": markdownRenderer{},
+}
+
+// selectRenderer picks the format from ?format=, then from the Accept header,
+// and falls back to WhatsApp so existing clients keep getting the same text.
+func selectRenderer(c *fiber.Ctx) (string, QuoteRenderer, error) {
+	format := c.Query("format")
+	if format == "" {
+		accept := c.Get(fiber.HeaderAccept)
+		switch {
+		case strings.Contains(accept, "text/html"):
+			format = "html"
+		case strings.Contains(accept, "text/markdown"):
+			format = "markdown"
+		default:
+			format = defaultRendererFormat
+		}
+	}
+	renderer, err := rendererFor(format)
+	return format, renderer, err
+}
+
+func rendererFor(format string) (QuoteRenderer, error) {
+	renderer, ok := quoteRenderers[format]
+	if !ok {
+		formats := make([]string, 0, len(quoteRenderers))
+		for name := range quoteRenderers {
+			formats = append(formats, name)
+		}
+		sort.Strings(formats)
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown format %q, expected one of %s", format, strings.Join(formats, ", ")))
+	}
+	return renderer, nil
+}
+
+// headerField is one "label : value" line above the prices
+type headerField struct {
+	Label string
+	Value string
+}
+
+func quotationHeaderFields(h QuotationHeader) []headerField {
+	fields := []headerField{
+		{"product", "box"},
+		{"machine", h.Machine},
+		{"shape", "shape such like open lid / hinged / cake / top bottom / drawer & etc"},
+		{"material", "(see below)"},
+		{"finishing", "die cut / die cut + gluing"},
+		{"quantity", fmt.Sprintf("(see below) For quantity more than %dpcs, please whatsapp us +60163443238 to request quotation due to paper price fluctuation issue.", h.MaxQuantity)},
+		{"print side", h.PrintSide},
+		{"colour", h.Colour},
+		{"print process", "6-7days (art card) / 8-10days (with beautify finishing) / 13-14days (carton material) excluded sat, sun, public holiday & pre-preparation works"},
+	}
+	if h.Blank != "" {
+		fields = append(fields[:1], append([]headerField{{"blank size", h.Blank}}, fields[1:]...)...)
+	}
+	return fields
+}
+
+// lineItemText is how a line item reads after the printing price, e.g.
+// "+ RM50.00 matt lam 1side", "- RM150.00 readied size shaped" or
+// "+ hot stamping not available"
+func lineItemText(item LineItem) string {
+	if !item.Available {
+		return fmt.Sprintf("+ %s not available", item.Label)
+	}
+	if item.Price < 0 {
+		return fmt.Sprintf("%s %s", priceText(item), item.Label)
+	}
+	return fmt.Sprintf("+ %s %s", priceText(item), item.Label)
+}
+
+// priceText is a line item's price as every format shows it
+func priceText(item LineItem) string {
+	if !item.Available {
+		return "not available"
+	}
+	return item.Price.Format()
+}
+
+// totalText is the total of a quantity, or why it can't be quoted, e.g.
+// "not quoted (hot stamping within 16 square inch is not available for 200 pcs)"
+func totalText(pricing *Pricing) string {
+	if !pricing.Available {
+		return fmt.Sprintf("not quoted (%s)", pricing.reason())
+	}
+	return pricing.Total.Format()
+}
+
+// estimateNote follows the total of a quantity whose price was interpolated
+func estimateNote(pricing *Pricing) string {
+	if pricing.Estimated && pricing.Available {
+		return " (estimate)"
+	}
+	return ""
+}
+
+// pricingText is the breakdown of one quantity, e.g. "RM300.00 Printing + RM50.00 matt lam 1side"
+func pricingText(pricing *Pricing) string {
+	parts := make([]string, 0, len(pricing.LineItems))
+	for _, item := range pricing.LineItems {
+		if item.Kind == LineItemPrinting && item.Available {
+			parts = append(parts, fmt.Sprintf("%s Printing", priceText(item)))
+			continue
+		}
+		if item.Kind == LineItemPrinting {
+			parts = append(parts, "Printing not available")
+			continue
+		}
+		parts = append(parts, lineItemText(item))
+	}
+	return strings.Join(parts, " ")
+}
+
+type whatsappRenderer struct{}
+
+func (whatsappRenderer) ContentType() string { return fiber.MIMETextPlainCharsetUTF8 }
+
+func (r whatsappRenderer) Render(record *QuotationRecord) (string, error) {
+	quotationStringTemplate := r.addPricingToTemplate("<Header>\n", record.Pricing)
+	return r.addHeaderToTemplate(quotationStringTemplate, record.Header), nil
+}
+
+// One WhatsApp line per quantity, e.g. "📌 *100 pcs*: RM300.00 Printing + RM50.00 matt lam 1side = RM350.00"
+func (whatsappRenderer) addPricingToTemplate(quotationStringTemplate string, pricings []*Pricing) string {
+	for _, pricing := range pricings {
+		line := fmt.Sprintf("📌 *%d pcs*: ", pricing.Quantity)
+		for _, item := range pricing.LineItems {
+			switch item.Kind {
+			case LineItemPrinting:
+				if !item.Available {
+					line += "Printing not available "
+					continue
+				}
+				line += fmt.Sprintf("%s Printing ", priceText(item))
+			case LineItemPrimaryAddOn:
+				line += lineItemText(item)
+			default:
+				line += " " + lineItemText(item)
+			}
+		}
+		quotationStringTemplate += line + fmt.Sprintf(" = %s%s\n\n", totalText(pricing), estimateNote(pricing))
+	}
+	return quotationStringTemplate
+}
+
+func (whatsappRenderer) addHeaderToTemplate(quotationStringTemplate string, h QuotationHeader) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*QUOTATION : BOX PRINTING : %s *\n", h.SizeCategory)
+	for _, field := range quotationHeaderFields(h) {
+		fmt.Fprintf(&b, "%s : %s\n", field.Label, field.Value)
+	}
+	fmt.Fprintf(&b, "\n\nestimated price :\n[ %s ] \n%s\n", h.Shape, h.Summary)
+	return strings.Replace(quotationStringTemplate, "<Header>", b.String(), -1)
+}
+
+// plainTextRenderer is the WhatsApp layout without emoji or asterisks, for SMS
+// and anywhere markup would show up literally
+type plainTextRenderer struct{}
+
+func (plainTextRenderer) ContentType() string { return fiber.MIMETextPlainCharsetUTF8 }
+
+func (plainTextRenderer) Render(record *QuotationRecord) (string, error) {
+	h := record.Header
+	var b strings.Builder
+	fmt.Fprintf(&b, "QUOTATION : BOX PRINTING : %s\n", h.SizeCategory)
+	if record.Number != "" {
+		fmt.Fprintf(&b, "quote no : %s\n", record.Number)
+	}
+	for _, field := range quotationHeaderFields(h) {
+		fmt.Fprintf(&b, "%s : %s\n", field.Label, field.Value)
+	}
+	fmt.Fprintf(&b, "\nestimated price :\n[ %s ]\n%s\n\n", h.Shape, h.Summary)
+	for _, pricing := range record.Pricing {
+		fmt.Fprintf(&b, "%d pcs: %s = %s%s\n", pricing.Quantity, pricingText(pricing), totalText(pricing), estimateNote(pricing))
+	}
+	return b.String(), nil
+}
+
+type markdownRenderer struct{}
+
+func (markdownRenderer) ContentType() string { return "text/markdown; charset=utf-8" }
+
+func (markdownRenderer) Render(record *QuotationRecord) (string, error) {
+	h := record.Header
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Quotation : Box Printing : %s\n\n", markdownEscape(h.SizeCategory))
+	if record.Number != "" {
+		fmt.Fprintf(&b, "**Quote no:** %s\n\n", record.Number)
+	}
+	for _, field := range quotationHeaderFields(h) {
+		fmt.Fprintf(&b, "- **%s:** %s\n", field.Label, markdownEscape(field.Value))
+	}
+	fmt.Fprintf(&b, "\n## Estimated price\n\n%s: %s\n\n", markdownEscape(h.Shape), markdownEscape(h.Summary))
+	b.WriteString("| Quantity | Breakdown | Total |\n|---:|---|---:|\n")
+	for _, pricing := range record.Pricing {
+		fmt.Fprintf(&b, "| %d pcs | %s | **%s**%s |\n", pricing.Quantity, markdownEscape(pricingText(pricing)), totalText(pricing), estimateNote(pricing))
+	}
+	return b.String(), nil
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "|", `\|`, "[", `\[`, "]", `\]`, "<", `\<`)
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// htmlEmailRenderer produces a self-contained HTML body with inline styles,
+// since most mail clients ignore style sheets
+type htmlEmailRenderer struct{}
+
+func (htmlEmailRenderer) ContentType() string { return fiber.MIMETextHTMLCharsetUTF8 }
+
+var htmlEmailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{"price": priceText, "total": totalText}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, Helvetica, sans-serif; font-size: 14px; color: #222;">
+<h2 style="color: #1c4787;">Quotation : Box Printing : {{ .Header.SizeCategory }}</h2>
+{{ if .Number }}<p><strong>Quote no:</strong> {{ .Number }}</p>{{ end }}
+<table style="border-collapse: collapse; margin-bottom: 16px;">
+{{ range .Fields }}<tr><td style="padding: 2px 12px 2px 0; font-weight: bold; vertical-align: top;">{{ .Label }}</td><td style="padding: 2px 0;">{{ .Value }}</td></tr>
+{{ end }}</table>
+<p><strong>Estimated price</strong><br>[ {{ .Header.Shape }} ] {{ .Header.Summary }}</p>
+<table style="border-collapse: collapse;">
+<tr style="background: #1c4787; color: #fff;"><th style="padding: 6px 10px; text-align: right;">Quantity</th><th style="padding: 6px 10px; text-align: left;">Breakdown</th><th style="padding: 6px 10px; text-align: right;">Total</th></tr>
+{{ range .Pricing }}<tr style="border-bottom: 1px solid #ddd;"><td style="padding: 6px 10px; text-align: right;">{{ .Quantity }} pcs</td><td style="padding: 6px 10px;">{{ range $i, $item := .LineItems }}{{ if $i }}<br>{{ end }}{{ $item.Label }}{{ if $item.Option }} {{ $item.Option }}{{ end }}: {{ price $item }}{{ end }}</td><td style="padding: 6px 10px; text-align: right; font-weight: bold;">{{ total . }}{{ if .Estimated }}<br><span style="font-weight: normal; font-size: 12px; color: #777;">estimate</span>{{ end }}</td></tr>
+{{ end }}</table>
+</body>
+</html>
+`))
+
+func (htmlEmailRenderer) Render(record *QuotationRecord) (string, error) {
+	h := record.Header
+	var b strings.Builder
+	err := htmlEmailTemplate.Execute(&b, map[string]interface{}{
+		"Number":  record.Number,
+		"Header":  h,
+		"Fields":  quotationHeaderFields(h),
+		"Pricing": record.Pricing,
+	})
+	return b.String(), err
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CompatibilityRule is a dependency between quotation fields, defined under
+// "rules" in the catalog. Fields are named by their JSON path, add-ons as
+// "addOns.<key>", and values are compared ignoring case; booleans are "true"
+// or "false". A rule applies when every field in When has its value (an empty
+// When always applies), and then:
+//   - every field in Requires must have its value
+//   - no field in Excludes may have its value
+//   - every field in Implies is set to its value
+//   - MaxAreaForSize limits how large an add-on may be on each size
+type CompatibilityRule struct {
+	ID             string            `json:"id"`
+	When           map[string]string `json:"when,omitempty"`
+	Requires       map[string]string `json:"requires,omitempty"`
+	Excludes       map[string]string `json:"excludes,omitempty"`
+	Implies        map[string]string `json:"implies,omitempty"`
+	MaxAreaForSize *AreaLimit        `json:"maxAreaForSize,omitempty"`
+	// Field is where a rejection is reported, Message explains the rule to
+	// customers. Both are worked out from the rule when left out.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// AreaLimit is the largest area of an add-on's options, as set by their
+// area in the catalog, allowed on each size category. Sizes not listed have
+// no limit.
+type AreaLimit struct {
+	AddOn   string             `json:"addOn"`
+	MaxArea map[string]float64 `json:"maxArea"`
+}
+
+// normaliseRules checks every rule only names fields and values the catalog
+// has, and fills in the field and message of rules that leave them out
+func (c *Catalog) normaliseRules() error {
+	seen := make(map[string]bool, len(c.Rules))
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.ID == "" {
+			return fmt.Errorf("invalid catalog: rule %d has no id", i+1)
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("invalid catalog: rule %s is listed more than once", rule.ID)
+		}
+		seen[rule.ID] = true
+		if err := c.normaliseRule(rule); err != nil {
+			return fmt.Errorf("invalid catalog: rule %s: %w", rule.ID, err)
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) normaliseRule(rule *CompatibilityRule) error {
+	if len(rule.Requires)+len(rule.Excludes)+len(rule.Implies) == 0 && rule.MaxAreaForSize == nil {
+		return errors.New("no requires, excludes, implies or maxAreaForSize")
+	}
+	for _, fields := range []map[string]string{rule.When, rule.Requires, rule.Excludes, rule.Implies} {
+		for field, value := range fields {
+			if err := c.checkRuleValue(field, value); err != nil {
+				return err
+			}
+		}
+	}
+	if limit := rule.MaxAreaForSize; limit != nil {
+		addOn, ok := c.addOn(limit.AddOn)
+		if !ok {
+			return fmt.Errorf("unknown add-on %q", limit.AddOn)
+		}
+		for size := range limit.MaxArea {
+			if _, ok := findOption(c.SizeCategories, size); !ok {
+				return fmt.Errorf("unknown size category %q", size)
+			}
+		}
+		if rule.Field == "" {
+			rule.Field = "addOns." + addOn.Key
+		}
+	}
+	if rule.Field == "" {
+		rule.Field = firstField(rule.Requires, rule.Excludes, rule.When)
+	}
+	if rule.Message == "" {
+		rule.Message = c.ruleMessage(*rule)
+	}
+	return nil
+}
+
+func (c *Catalog) checkRuleValue(field string, value string) error {
+	switch field {
+	case "readiedSize", "isDoubleSide":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false, not %q", field, value)
+		}
+		return nil
+	}
+	options, ok := c.fieldOptions(field)
+	if !ok {
+		return fmt.Errorf("unknown field %q", field)
+	}
+	if _, ok := findOption(options, value); !ok {
+		return fmt.Errorf("%s has no option %q", field, value)
+	}
+	return nil
+}
+
+// fieldOptions returns the catalog options of a field
+func (c *Catalog) fieldOptions(field string) ([]CatalogOption, bool) {
+	switch field {
+	case "sizeCategory":
+		return c.SizeCategories, true
+	case "material":
+		return c.Materials, true
+	case "noOfColours":
+		return c.NoOfColours, true
+	case "customerTier":
+		return c.CustomerTiers, true
+	}
+	if key, ok := strings.CutPrefix(field, "addOns."); ok {
+		if addOn, ok := c.addOn(key); ok {
+			return addOn.Options, true
+		}
+	}
+	return nil, false
+}
+
+func findOption(options []CatalogOption, key string) (CatalogOption, bool) {
+	for _, option := range options {
+		if strings.EqualFold(option.Key, key) {
+			return option, true
+		}
+	}
+	return CatalogOption{}, false
+}
+
+// firstField picks the field a rule reports on when it doesn't say
+func firstField(fields ...map[string]string) string {
+	for _, values := range fields {
+		if len(values) > 0 {
+			return sortedKeys(values)[0]
+		}
+	}
+	return ""
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r CompatibilityRule) appliesTo(q *Quotation, catalog *Catalog) bool {
+	for field, value := range r.When {
+		if !q.fieldIs(catalog, field, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r CompatibilityRule) violatedBy(q *Quotation, catalog *Catalog) bool {
+	for field, value := range r.Requires {
+		// a missing value is reported by the catalog checks already
+		if actual := q.fieldValue(catalog, field); actual != "" && !strings.EqualFold(actual, value) {
+			return true
+		}
+	}
+	for field, value := range r.Excludes {
+		if q.fieldIs(catalog, field, value) {
+			return true
+		}
+	}
+	return r.areaExceededBy(q, catalog)
+}
+
+func (r CompatibilityRule) areaExceededBy(q *Quotation, catalog *Catalog) bool {
+	if r.MaxAreaForSize == nil {
+		return false
+	}
+	var maxArea float64
+	found := false
+	// a size without a limit of its own has its parent's
+	for _, size := range catalog.sizeLineage(q.SizeCategory) {
+		if maxArea, found = r.MaxAreaForSize.MaxArea[size]; found {
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	addOn, _ := catalog.addOn(r.MaxAreaForSize.AddOn)
+	option, ok := findOption(addOn.Options, q.addOnOption(addOn.Key))
+	return ok && option.Area > maxArea
+}
+
+// applyImplications sets the fields implied by every rule that applies, e.g.
+// clearing the other side's finishing of a single side quotation
+func (q *Quotation) applyImplications(catalog *Catalog) {
+	for _, rule := range catalog.Rules {
+		if len(rule.Implies) == 0 || !rule.appliesTo(q, catalog) {
+			continue
+		}
+		for _, field := range sortedKeys(rule.Implies) {
+			q.setFieldValue(catalog, field, rule.Implies[field])
+		}
+	}
+}
+
+// ruleMessage says in words what a rule asks for, for rules without a message
+// of their own, e.g. "print another side can't be yes with colours 0colour"
+func (c *Catalog) ruleMessage(rule CompatibilityRule) string {
+	var parts []string
+	for _, field := range sortedKeys(rule.Requires) {
+		parts = append(parts, fmt.Sprintf("%s must be %s", c.fieldLabel(field), c.valueLabel(field, rule.Requires[field])))
+	}
+	for _, field := range sortedKeys(rule.Excludes) {
+		parts = append(parts, fmt.Sprintf("%s can't be %s", c.fieldLabel(field), c.valueLabel(field, rule.Excludes[field])))
+	}
+	for _, field := range sortedKeys(rule.Implies) {
+		parts = append(parts, fmt.Sprintf("%s is set to %s", c.fieldLabel(field), c.valueLabel(field, rule.Implies[field])))
+	}
+	message := strings.Join(parts, " and ")
+	if when := c.describe(rule.When); when != "" && message != "" {
+		message += " with " + when
+	}
+	if limit := rule.MaxAreaForSize; limit != nil {
+		sizes := make([]string, 0, len(limit.MaxArea))
+		for size := range limit.MaxArea {
+			sizes = append(sizes, size)
+		}
+		sort.Strings(sizes)
+		limits := make([]string, 0, len(sizes))
+		for _, size := range sizes {
+			limits = append(limits, fmt.Sprintf("%g on %s", limit.MaxArea[size], c.valueLabel("sizeCategory", size)))
+		}
+		if message != "" {
+			message += "; "
+		}
+		message += fmt.Sprintf("%s is limited to %s", c.fieldLabel("addOns."+limit.AddOn), strings.Join(limits, ", "))
+	}
+	return message
+}
+
+// describe lists field values in words, e.g. "colours 0colour and print another side yes"
+func (c *Catalog) describe(values map[string]string) string {
+	parts := make([]string, 0, len(values))
+	for _, field := range sortedKeys(values) {
+		parts = append(parts, fmt.Sprintf("%s %s", c.fieldLabel(field), c.valueLabel(field, values[field])))
+	}
+	return strings.Join(parts, " and ")
+}
+
+func (c *Catalog) fieldLabel(field string) string {
+	switch field {
+	case "sizeCategory":
+		return "size"
+	case "noOfColours":
+		return "colours"
+	case "readiedSize":
+		return "readied size shape"
+	case "isDoubleSide":
+		return "print another side"
+	case "customerTier":
+		return "customer tier"
+	}
+	if key, ok := strings.CutPrefix(field, "addOns."); ok {
+		if addOn, ok := c.addOn(key); ok {
+			return addOn.Label
+		}
+	}
+	return field
+}
+
+func (c *Catalog) valueLabel(field string, value string) string {
+	if b, err := strconv.ParseBool(value); err == nil && (field == "readiedSize" || field == "isDoubleSide") {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	if options, ok := c.fieldOptions(field); ok {
+		if option, ok := findOption(options, value); ok {
+			return option.Label
+		}
+	}
+	return value
+}
+
+// fieldValue returns a quotation field by its JSON path
+func (q *Quotation) fieldValue(catalog *Catalog, field string) string {
+	switch field {
+	case "sizeCategory":
+		return q.SizeCategory
+	case "material":
+		return q.Material
+	case "noOfColours":
+		return q.NoOfColours
+	case "readiedSize":
+		return strconv.FormatBool(q.ReadiedSize)
+	case "isDoubleSide":
+		return strconv.FormatBool(q.IsDoubleSide)
+	case "customerTier":
+		return q.CustomerTier
+	}
+	// an add-on by its type, e.g. "addOns.hotstamping". An add-on left out
+	// of the list has its none option.
+	if key, ok := strings.CutPrefix(field, "addOns."); ok {
+		if option := q.addOnOption(key); option != "" {
+			return option
+		}
+		addOnType, _ := catalog.addOn(key)
+		return addOnType.None
+	}
+	return ""
+}
+
+// fieldIs is whether a field has value. A size category also is its parent
+// sizes, so a rule for A4 covers A4+.
+func (q *Quotation) fieldIs(catalog *Catalog, field string, value string) bool {
+	if field == "sizeCategory" {
+		for _, size := range catalog.sizeLineage(q.SizeCategory) {
+			if strings.EqualFold(size, value) {
+				return true
+			}
+		}
+	}
+	return strings.EqualFold(q.fieldValue(catalog, field), value)
+}
+
+func (q *Quotation) setFieldValue(catalog *Catalog, field string, value string) {
+	switch field {
+	case "sizeCategory":
+		q.SizeCategory = value
+	case "material":
+		q.Material = value
+	case "noOfColours":
+		q.NoOfColours = value
+	case "readiedSize":
+		q.ReadiedSize, _ = strconv.ParseBool(value)
+	case "isDoubleSide":
+		q.IsDoubleSide, _ = strconv.ParseBool(value)
+	case "customerTier":
+		q.CustomerTier = value
+	}
+	if key, ok := strings.CutPrefix(field, "addOns."); ok {
+		for i := range q.AddOns {
+			if q.AddOns[i].Type == key {
+				q.AddOns[i].Option = value
+				return
+			}
+		}
+		// an add-on left out already has its none option
+		if addOn, _ := catalog.addOn(key); !strings.EqualFold(addOn.None, value) {
+			q.AddOns = append(q.AddOns, SelectedAddOn{Type: key, Option: value})
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SizeSpec is the physical side of a size category: its flat sheet size and
+// what it can be made on. Fields left out are taken from the parent size, so
+// A4+ gets A4's limits unless it has its own.
+type SizeSpec struct {
+	WidthMM  float64  `json:"widthMm,omitempty"`
+	HeightMM float64  `json:"heightMm,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+	// Parent is the size this one is a variant of, e.g. A4 for A4+. Rules and
+	// discounts for the parent apply to it too.
+	Parent string `json:"parent,omitempty"`
+	// MaxStampArea is the largest hot stamping or emboss/deboss area, in the
+	// units of the add-on option areas, 0 for no limit
+	MaxStampArea float64  `json:"maxStampArea,omitempty"`
+	Presses      []string `json:"presses,omitempty"`
+}
+
+// normaliseSizes checks the sizes' dimensions and parents, and that no alias
+// could mean two sizes
+func (c *Catalog) normaliseSizes() error {
+	names := make(map[string]string, len(c.SizeCategories))
+	for _, size := range c.SizeCategories {
+		names[strings.ToLower(size.Key)] = size.Key
+	}
+	for _, size := range c.SizeCategories {
+		spec := size.SizeSpec
+		if spec == nil {
+			continue
+		}
+		if spec.WidthMM < 0 || spec.HeightMM < 0 || (spec.WidthMM == 0) != (spec.HeightMM == 0) {
+			return fmt.Errorf("invalid catalog: size %s needs a positive widthMm and heightMm", size.Key)
+		}
+		if spec.MaxStampArea < 0 {
+			return fmt.Errorf("invalid catalog: size %s has a negative maxStampArea", size.Key)
+		}
+		for _, alias := range spec.Aliases {
+			if other, ok := names[strings.ToLower(alias)]; ok && other != size.Key {
+				return fmt.Errorf("invalid catalog: size %s alias %q is already %s", size.Key, alias, other)
+			}
+			names[strings.ToLower(alias)] = size.Key
+		}
+		for _, press := range spec.Presses {
+			if strings.TrimSpace(press) == "" {
+				return fmt.Errorf("invalid catalog: size %s has a blank press", size.Key)
+			}
+		}
+		if spec.Parent != "" {
+			if _, ok := findOption(c.SizeCategories, spec.Parent); !ok {
+				return fmt.Errorf("invalid catalog: size %s has unknown parent %q", size.Key, spec.Parent)
+			}
+		}
+	}
+	for _, size := range c.SizeCategories {
+		if lineage := c.sizeLineage(size.Key); len(lineage) > len(c.SizeCategories) {
+			return fmt.Errorf("invalid catalog: size %s is its own parent", size.Key)
+		}
+	}
+	return nil
+}
+
+// size finds a size category by its key or one of its aliases, ignoring case
+func (c *Catalog) size(name string) (CatalogOption, bool) {
+	if size, ok := findOption(c.SizeCategories, name); ok {
+		return size, true
+	}
+	for _, size := range c.SizeCategories {
+		if size.SizeSpec == nil {
+			continue
+		}
+		for _, alias := range size.Aliases {
+			if strings.EqualFold(alias, name) {
+				return size, true
+			}
+		}
+	}
+	return CatalogOption{}, false
+}
+
+// sizeLineage is the size followed by its parent, its parent's parent and so
+// on. It stops one past the number of sizes when the parents go round in a
+// circle.
+func (c *Catalog) sizeLineage(key string) []string {
+	var lineage []string
+	for key != "" && len(lineage) <= len(c.SizeCategories) {
+		size, ok := findOption(c.SizeCategories, key)
+		if !ok {
+			break
+		}
+		lineage = append(lineage, size.Key)
+		key = ""
+		if size.SizeSpec != nil {
+			key = size.Parent
+		}
+	}
+	return lineage
+}
+
+// sizeSpec is a size's spec with the fields it leaves out taken from its parents
+func (c *Catalog) sizeSpec(key string) SizeSpec {
+	var spec SizeSpec
+	for _, name := range c.sizeLineage(key) {
+		size, _ := findOption(c.SizeCategories, name)
+		if size.SizeSpec == nil {
+			continue
+		}
+		if spec.WidthMM == 0 {
+			spec.WidthMM, spec.HeightMM = size.WidthMM, size.HeightMM
+		}
+		if spec.MaxStampArea == 0 {
+			spec.MaxStampArea = size.MaxStampArea
+		}
+		if len(spec.Presses) == 0 {
+			spec.Presses = size.Presses
+		}
+		if spec.Parent == "" {
+			spec.Parent = size.Parent
+		}
+	}
+	return spec
+}
+
+// formSize is what the quotation form needs of a size to apply the rules
+// as the server does
+type formSize struct {
+	Lineage      []string `json:"lineage"`
+	MaxStampArea float64  `json:"maxStampArea"`
+}
+
+func (c *Catalog) formSizes() map[string]formSize {
+	sizes := make(map[string]formSize, len(c.SizeCategories))
+	for _, size := range c.SizeCategories {
+		sizes[size.Key] = formSize{Lineage: c.sizeLineage(size.Key), MaxStampArea: c.sizeSpec(size.Key).MaxStampArea}
+	}
+	return sizes
+}
+
+// AreaMM2 is the flat sheet area, 0 when the size has no dimensions
+func (s SizeSpec) AreaMM2() float64 {
+	return s.WidthMM * s.HeightMM
+}
+
+// printsOn is whether the size fits press, a size without a press list
+// fitting every press
+func (s SizeSpec) printsOn(press string) bool {
+	if len(s.Presses) == 0 {
+		return true
+	}
+	for _, p := range s.Presses {
+		if strings.EqualFold(p, press) {
+			return true
+		}
+	}
+	return false
+}
+
+// normaliseSize spells the size category the way the catalog does, so an
+// alias like "A4 plus" is priced as A4+
+func (q *Quotation) normaliseSize(catalog *Catalog) {
+	if size, ok := catalog.size(q.SizeCategory); ok {
+		q.SizeCategory = size.Key
+	}
+}
+
+// sizes is the quotation's size followed by its parents, the order prices
+// are looked up in
+func (q *Quotation) sizes(catalog *Catalog) []string {
+	if sizes := catalog.sizeLineage(q.SizeCategory); len(sizes) > 0 {
+		return sizes
+	}
+	return []string{q.SizeCategory}
+}
+
+// validateStampArea checks every add-on option with an area fits the size
+func (q *Quotation) validateStampArea(errs *ValidationErrors, catalog *Catalog) {
+	maxArea := catalog.sizeSpec(q.SizeCategory).MaxStampArea
+	if maxArea == 0 {
+		return
+	}
+	for i, addOn := range q.AddOns {
+		addOnType, ok := catalog.addOn(addOn.Type)
+		if !ok {
+			continue
+		}
+		if option, ok := addOnType.option(addOn.Option); ok && option.Area > maxArea {
+			errs.add(fmt.Sprintf("addOns[%d].option", i), "%s %s is larger than the %s area of %g", addOnType.Label, option.Label, q.SizeCategory, maxArea)
+		}
+	}
+}
+
+// sizeSpecFromCells reads the size columns of a sizeCategories row of the
+// catalog sheet tab: width mm, height mm, aliases (separated by "|"),
+// parent, max stamp area, presses (separated by "|")
+func sizeSpecFromCells(cells []string) (*SizeSpec, error) {
+	for len(cells) > 0 && cells[len(cells)-1] == "" {
+		cells = cells[:len(cells)-1]
+	}
+	if len(cells) == 0 {
+		return nil, nil
+	}
+	cells = append(cells, make([]string, 6)...)
+	spec := &SizeSpec{Aliases: splitList(cells[2]), Parent: cells[3], Presses: splitList(cells[5])}
+	numbers := []struct {
+		name  string
+		text  string
+		value *float64
+	}{{"width", cells[0], &spec.WidthMM}, {"height", cells[1], &spec.HeightMM}, {"max stamp area", cells[4], &spec.MaxStampArea}}
+	for _, number := range numbers {
+		if number.text == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(number.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("size %s %q is not a number", number.name, number.text)
+		}
+		*number.value = value
+	}
+	return spec, nil
+}
+
+func splitList(text string) []string {
+	var values []string
+	for _, value := range strings.Split(text, "|") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
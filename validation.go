@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FieldError reports one invalid Quotation field, named by its JSON path
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Rule is the catalog rule the quotation breaks, if any
+	Rule string `json:"rule,omitempty"`
+}
+
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, fieldError := range v {
+		messages = append(messages, fmt.Sprintf("%s: %s", fieldError.Field, fieldError.Message))
+	}
+	return "invalid quotation: " + strings.Join(messages, "; ")
+}
+
+func (v *ValidationErrors) add(field string, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *ValidationErrors) checkOneOf(field string, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	if value == "" {
+		v.add(field, "is required")
+		return
+	}
+	v.add(field, "%q is not one of %s", value, strings.Join(allowed, ", "))
+}
+
+// validate checks every field of the quotation against the catalog, plus the
+// catalog rules between them. The values implied by the rules are set first.
+// It returns nil when the quotation can be priced.
+func (q *Quotation) validate(catalog *Catalog) ValidationErrors {
+	var errs ValidationErrors
+	q.applyBox(&errs, catalog)
+	q.normaliseSize(catalog)
+	q.applyImplications(catalog)
+
+	errs.checkOneOf("sizeCategory", q.SizeCategory, enabledKeys(catalog.SizeCategories))
+	errs.checkOneOf("material", q.Material, enabledKeys(catalog.Materials))
+	errs.checkOneOf("noOfColours", q.NoOfColours, enabledKeys(catalog.NoOfColours))
+	q.validateQuantity(&errs, catalog.quantities())
+
+	q.validateAddOns(&errs, catalog)
+	q.validateStampArea(&errs, catalog)
+
+	if len(q.Customer) > 200 {
+		errs.add("customer", "must be at most 200 characters")
+	}
+	if q.CustomerTier != "" {
+		errs.checkOneOf("customerTier", q.CustomerTier, enabledKeys(catalog.CustomerTiers))
+	}
+
+	for _, rule := range catalog.Rules {
+		if rule.appliesTo(q, catalog) && rule.violatedBy(q, catalog) {
+			errs = append(errs, FieldError{Field: rule.Field, Message: rule.Message, Rule: rule.ID})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (q *Quotation) validateQuantity(errs *ValidationErrors, quantityRange []int) {
+	if len(q.Quantity) == 0 {
+		errs.add("quantity", "at least one quantity is required")
+		return
+	}
+	for i, quantity := range q.Quantity {
+		// interpolated pricing can estimate any quantity, not just the sheet breakpoints
+		if quantityPricing.interpolates() {
+			if quantity <= 0 || quantity > quantityPricing.MaxQuantity {
+				errs.add(fmt.Sprintf("quantity[%d]", i), "%d must be between 1 and %d", quantity, quantityPricing.MaxQuantity)
+			}
+			continue
+		}
+		if !containsInt(quantityRange, quantity) {
+			errs.add(fmt.Sprintf("quantity[%d]", i), "%d is not one of %s", quantity, strings.Trim(fmt.Sprint(quantityRange), "[]"))
+		}
+	}
+	if !sort.SliceIsSorted(q.Quantity, func(i, j int) bool { return q.Quantity[i] < q.Quantity[j] }) {
+		errs.add("quantity", "quantities must be in ascending order")
+		return
+	}
+	for i := 1; i < len(q.Quantity); i++ {
+		if q.Quantity[i] == q.Quantity[i-1] {
+			errs.add(fmt.Sprintf("quantity[%d]", i), "%d is listed more than once", q.Quantity[i])
+		}
+	}
+}
+
+// validateAddOns checks every selected add-on is a catalog add-on with one of
+// its enabled options. Add-ons left out of the list are not wanted.
+func (q *Quotation) validateAddOns(errs *ValidationErrors, catalog *Catalog) {
+	seen := make(map[string]bool, len(q.AddOns))
+	for i, addOn := range q.AddOns {
+		field := fmt.Sprintf("addOns[%d]", i)
+		addOnType, ok := catalog.addOn(addOn.Type)
+		if !ok {
+			keys := make([]string, 0, len(catalog.AddOns))
+			for _, t := range catalog.AddOns {
+				keys = append(keys, t.Key)
+			}
+			errs.checkOneOf(field+".type", addOn.Type, keys)
+			continue
+		}
+		if seen[addOn.Type] {
+			errs.add(field+".type", "%s is listed more than once", addOn.Type)
+			continue
+		}
+		seen[addOn.Type] = true
+		errs.checkOneOf(field+".option", addOn.Option, enabledKeys(addOnType.Options))
+	}
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validationErrorResponse(c *fiber.Ctx, errs ValidationErrors) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"errors": errs})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fieldErrors is each error's field, followed by its rule when it breaks one
+func fieldErrors(errs ValidationErrors) []string {
+	fields := []string{}
+	for _, fieldError := range errs {
+		field := fieldError.Field
+		if fieldError.Rule != "" {
+			field += " " + fieldError.Rule
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(q *Quotation)
+		want   []string
+	}{
+		{"valid", func(q *Quotation) {}, []string{}},
+		{"size alias", func(q *Quotation) { q.SizeCategory = "A4 plus" }, []string{}},
+		{"missing size", func(q *Quotation) { q.SizeCategory = "" }, []string{"sizeCategory"}},
+		{"unknown material", func(q *Quotation) { q.Material = "newsprint" }, []string{"material"}},
+		{"unknown colours", func(q *Quotation) { q.NoOfColours = "2colours" }, []string{"noOfColours"}},
+		{"no quantity", func(q *Quotation) { q.Quantity = nil }, []string{"quantity"}},
+		{"quantity not offered", func(q *Quotation) { q.Quantity = []int{100, 250} }, []string{"quantity[1]"}},
+		{"quantities out of order", func(q *Quotation) { q.Quantity = []int{200, 100} }, []string{"quantity"}},
+		{"quantity twice", func(q *Quotation) { q.Quantity = []int{100, 100} }, []string{"quantity[1]"}},
+		{"unknown add-on", func(q *Quotation) { q.AddOns = []SelectedAddOn{{"foil", "gold"}} }, []string{"addOns[0].type"}},
+		{"unknown add-on option", func(q *Quotation) { q.AddOns = []SelectedAddOn{{"string", "40inch"}} }, []string{"addOns[0].option"}},
+		{"add-on twice", func(q *Quotation) {
+			q.AddOns = []SelectedAddOn{{"string", "12inch"}, {"string", "14inch"}}
+		}, []string{"addOns[1].type"}},
+		{"stamp larger than the size", func(q *Quotation) {
+			q.AddOns = []SelectedAddOn{{"hotstamping", "within 32 square inch"}}
+		}, []string{"addOns[0].option"}},
+		{"stamp on a size's parent", func(q *Quotation) {
+			q.SizeCategory = "A5+"
+			q.AddOns = []SelectedAddOn{{"hotstamping", "within 16 square inch"}}
+		}, []string{}},
+		{"unknown customer tier", func(q *Quotation) { q.CustomerTier = "vip" }, []string{"customerTier"}},
+		{"spot uv without matt lam", func(q *Quotation) {
+			q.AddOns = []SelectedAddOn{{"spotUV1Side", "spotUV1Side"}}
+		}, []string{"addOns.spotUV1Side spotUVRequiresMattLam"}},
+		{"spot uv on matt lam", func(q *Quotation) {
+			q.AddOns = []SelectedAddOn{{"surfaceProtectionPrinting", "matt lam 1side"}, {"spotUV1Side", "spotUV1Side"}}
+		}, []string{}},
+		{"double side without colour", func(q *Quotation) {
+			q.NoOfColours = "0colour"
+			q.IsDoubleSide = true
+		}, []string{"isDoubleSide noDoubleSideWithoutColour"}},
+	}
+	catalog := testCatalog(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQuotation()
+			tt.modify(q)
+			if got := fieldErrors(q.validate(catalog)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAppliesImplications(t *testing.T) {
+	q := testQuotation(SelectedAddOn{"finishingAnotherSide", "gloss lam 1side"})
+	if errs := q.validate(testCatalog(t)); errs != nil {
+		t.Fatal(errs)
+	}
+	// a single side quotation has no finishing on the other side
+	if got := q.addOnOption("finishingAnotherSide"); got != "no finishing (may cause colour rubbing issue)" {
+		t.Errorf("finishingAnotherSide is %q", got)
+	}
+}
+
+func TestNormaliseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    CompatibilityRule
+		wantErr bool
+	}{
+		{"excludes", CompatibilityRule{ID: "r", When: map[string]string{"sizeCategory": "A2"}, Excludes: map[string]string{"material": "boxboard 350gsm"}}, false},
+		{"area limit", CompatibilityRule{ID: "r", MaxAreaForSize: &AreaLimit{AddOn: "embossDeboss", MaxArea: map[string]float64{"A5": 16}}}, false},
+		{"nothing to check", CompatibilityRule{ID: "r", When: map[string]string{"sizeCategory": "A2"}}, true},
+		{"unknown field", CompatibilityRule{ID: "r", Requires: map[string]string{"paper": "art card 350gsm"}}, true},
+		{"unknown value", CompatibilityRule{ID: "r", Requires: map[string]string{"material": "newsprint"}}, true},
+		{"not a boolean", CompatibilityRule{ID: "r", Excludes: map[string]string{"isDoubleSide": "maybe"}}, true},
+		{"unknown add-on", CompatibilityRule{ID: "r", MaxAreaForSize: &AreaLimit{AddOn: "foil", MaxArea: map[string]float64{"A5": 16}}}, true},
+		{"unknown size", CompatibilityRule{ID: "r", MaxAreaForSize: &AreaLimit{AddOn: "embossDeboss", MaxArea: map[string]float64{"B5": 16}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := testCatalog(t)
+			catalog.Rules = []CompatibilityRule{tt.rule}
+			err := catalog.normaliseRules()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (catalog.Rules[0].Field == "" || catalog.Rules[0].Message == "") {
+				t.Errorf("rule has no field or message: %+v", catalog.Rules[0])
+			}
+		})
+	}
+}
+
+func TestAreaLimitRule(t *testing.T) {
+	catalog := testCatalog(t)
+	catalog.Rules = append(catalog.Rules, CompatibilityRule{ID: "smallEmboss", MaxAreaForSize: &AreaLimit{AddOn: "embossDeboss", MaxArea: map[string]float64{"A4": 16}}})
+	if err := catalog.normaliseRules(); err != nil {
+		t.Fatal(err)
+	}
+	for size, want := range map[string][]string{
+		"A4":  {"addOns.embossDeboss smallEmboss"},
+		"A4+": {"addOns.embossDeboss smallEmboss"},
+		"A3":  {},
+	} {
+		q := testQuotation(SelectedAddOn{"embossDeboss", "within 24 square inch"})
+		q.SizeCategory = size
+		if got := fieldErrors(q.validate(catalog)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", size, got, want)
+		}
+	}
+}
+
+func TestNormaliseAddOns(t *testing.T) {
+	q := testQuotation(SelectedAddOn{"string", "12inch"})
+	q.PrimaryAddOns = &PrimaryAddOns{SurfaceProtectionPrinting: "matt lam 1side"}
+	q.SecondaryAddOns = &SecondaryAddOns{SpotUV1Side: "spotUV1side", String: "14inch"}
+	q.ThirdAddOns = &ThirdAddOns{IsDoubleSide: true}
+	catalog := testCatalog(t)
+	q.normaliseAddOns(catalog)
+	// the list wins over a legacy group, and options are spelt as the catalog has them
+	want := []SelectedAddOn{{"string", "12inch"}, {"surfaceProtectionPrinting", "matt lam 1side"}, {"spotUV1Side", "spotUV1Side"}}
+	if !reflect.DeepEqual(q.AddOns, want) || !q.IsDoubleSide || q.PrimaryAddOns != nil || q.SecondaryAddOns != nil || q.ThirdAddOns != nil {
+		t.Errorf("got %+v", q)
+	}
+	if errs := q.validate(catalog); errs != nil {
+		t.Error(errs)
+	}
+}